@@ -0,0 +1,117 @@
+package apkexporter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/bundletool"
+)
+
+// Signing kinds of the exported APK.
+const (
+	SigningRelease = "release"
+	SigningDebug   = "debug"
+)
+
+// ManifestDumper represents an APKBuilder that can print the manifest of a bundle.
+type ManifestDumper interface {
+	DumpManifest(aabPath string, opts bundletool.DumpManifestOptions) *command.Model
+}
+
+// APKNameData is the data the APK name template is executed with.
+// The artifact info fields are promoted, for example: {{.Module}}, {{.ProductFlavour}}, {{.BuildType}}.
+type APKNameData struct {
+	ArtifactInfo
+
+	PackageName string
+	VersionName string
+	VersionCode string
+	// Signing is SigningRelease if the APK is signed with the configured keystore, SigningDebug otherwise.
+	Signing string
+}
+
+// APKNameTemplate is a text/template rendering the universal APK's file name.
+// The env function returns the value of an environment variable: {{env "BITRISE_BUILD_NUMBER"}}.
+type APKNameTemplate struct {
+	template *template.Template
+}
+
+// ParseAPKNameTemplate parses the template, and checks it by executing it with empty data.
+func ParseAPKNameTemplate(text string) (*APKNameTemplate, error) {
+	tmpl, err := template.New("apk_name").Option("missingkey=error").Funcs(template.FuncMap{"env": os.Getenv}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, APKNameData{}); err != nil {
+		return nil, err
+	}
+	return &APKNameTemplate{template: tmpl}, nil
+}
+
+// Name renders the file name, the .apk extension is added if missing.
+func (t APKNameTemplate) Name(data APKNameData) (string, error) {
+	var b bytes.Buffer
+	if err := t.template.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(b.String())
+	switch {
+	case strings.TrimSuffix(name, apkExtension) == "":
+		return "", errors.New("the APK name template rendered an empty name")
+	case strings.ContainsAny(name, `/\`):
+		return "", fmt.Errorf("the APK name must not contain path separators: %s", name)
+	case !strings.HasSuffix(name, apkExtension):
+		name += apkExtension
+	}
+	return name, nil
+}
+
+// WithAPKNameTemplate returns a copy of the exporter which names the universal APKs with the template,
+// instead of UniversalAPKBase. The manifest values are read from the bundle with bundletool.
+func (exporter Exporter) WithAPKNameTemplate(tmpl *APKNameTemplate) Exporter {
+	exporter.apkNameTemplate = tmpl
+	return exporter
+}
+
+// universalAPKName returns the file name of the bundle's universal APK.
+func (exporter Exporter) universalAPKName(aabPath string, releaseSigned bool) (string, error) {
+	if exporter.apkNameTemplate == nil {
+		return UniversalAPKBase(aabPath), nil
+	}
+
+	dumper, ok := exporter.apkBuilder.(ManifestDumper)
+	if !ok {
+		return "", errors.New("the APK builder can not read the bundle's manifest")
+	}
+	cmd := dumper.DumpManifest(aabPath, bundletool.DumpManifestOptions{})
+	out, err := cmd.RunAndReturnTrimmedOutput()
+	if err := handleError(cmd.PrintableCommandArgs(), out, err); err != nil {
+		return "", err
+	}
+	manifest, err := bundletool.ParseManifest(out)
+	if err != nil {
+		return "", err
+	}
+
+	data := APKNameData{
+		ArtifactInfo: ResolveArtifactInfo(aabPath),
+		PackageName:  manifest.Package,
+		VersionName:  manifest.VersionName,
+		VersionCode:  manifest.VersionCode,
+		Signing:      SigningDebug,
+	}
+	if releaseSigned {
+		data.Signing = SigningRelease
+	}
+	name, err := exporter.apkNameTemplate.Name(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render the APK name of %s: %w", aabPath, err)
+	}
+	return name, nil
+}
@@ -0,0 +1,82 @@
+package apkexporter
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/bundletool"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_universalAPKName_Template(t *testing.T) {
+	// Given
+	t.Setenv("APP_NAME", "MyApp")
+	tmpl, err := ParseAPKNameTemplate(`{{env "APP_NAME"}}_{{.VersionName}}_({{.VersionCode}})_{{.ProductFlavour}}`)
+	require.NoError(t, err)
+	apkBuilder := &MockManifestDumper{
+		MockAPKBuilder: *givenMockedAPKBuilder(givenSuccessfulCommand()),
+		manifest:       `<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="io.bitrise.sample" android:versionCode="4512" android:versionName="2.3.1"/>`,
+	}
+	exporter := givenExporter(apkBuilder, givenMockFileDownloader()).WithAPKNameTemplate(tmpl)
+
+	// When
+	name, err := exporter.universalAPKName("/path/to/app-prod-release.aab", true)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "MyApp_2.3.1_(4512)_prod.apk", name)
+}
+
+func Test_universalAPKName_Default(t *testing.T) {
+	// Given
+	exporter := givenExporter(givenMockedAPKBuilder(givenSuccessfulCommand()), givenMockFileDownloader())
+
+	// When
+	name, err := exporter.universalAPKName("/path/to/app-prod-release.aab", true)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "app-prod-universal-release.apk", name)
+}
+
+func Test_ParseAPKNameTemplate_UnknownField(t *testing.T) {
+	// When
+	_, err := ParseAPKNameTemplate("{{.Flavor}}.apk")
+
+	// Then
+	require.Error(t, err)
+}
+
+func Test_APKNameTemplate_Name(t *testing.T) {
+	// Given
+	tmpl, err := ParseAPKNameTemplate("{{.PackageName}}-{{.BuildType}}-{{.Signing}}.apk")
+	require.NoError(t, err)
+
+	// When
+	name, err := tmpl.Name(APKNameData{ArtifactInfo: ArtifactInfo{BuildType: "release"}, PackageName: "io.bitrise.sample", Signing: SigningDebug})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "io.bitrise.sample-release-debug.apk", name)
+}
+
+func Test_APKNameTemplate_NamePathSeparator(t *testing.T) {
+	// Given
+	tmpl, err := ParseAPKNameTemplate("{{.ProductFlavour}}")
+	require.NoError(t, err)
+
+	// When
+	_, err = tmpl.Name(APKNameData{ArtifactInfo: ArtifactInfo{ProductFlavour: "../prod"}})
+
+	// Then
+	require.EqualError(t, err, "the APK name must not contain path separators: ../prod")
+}
+
+type MockManifestDumper struct {
+	MockAPKBuilder
+	manifest string
+}
+
+func (m *MockManifestDumper) DumpManifest(aabPath string, opts bundletool.DumpManifestOptions) *command.Model {
+	return command.New("echo", m.manifest)
+}
@@ -3,29 +3,66 @@ package apkexporter
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/bitrise-io/go-utils/command"
 	"github.com/bitrise-io/go-utils/errorutil"
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/apksignature"
 	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/bundletool"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/workspace"
 )
 
 const (
-	passPrefix    = "pass:"
 	fileSchema    = "file://"
 	apksExtension = ".apks"
 	apkExtension  = ".apk"
+
+	outOfMemoryError = "java.lang.OutOfMemoryError"
 )
 
 // APKBuilder represents a type that can run a commmand that generates an universal APK from AAB.
 type APKBuilder interface {
 	BuildAPKs(aabPath, apksPath string, keystoreCfg *bundletool.KeystoreConfig) *command.Model
+	BuildAPKsWithOptions(aabPath, apksPath string, opts bundletool.BuildAPKsOptions, keystoreCfg *bundletool.KeystoreConfig) *command.Model
+	ExtractAPKs(apksPath, outputDir, deviceSpecPath string) *command.Model
+}
+
+// HeapScalableAPKBuilder represents an APKBuilder that can be recreated with a larger JVM heap.
+type HeapScalableAPKBuilder interface {
+	APKBuilder
+	WithLargerHeap() APKBuilder
+}
+
+// heapScalableTool is the HeapScalableAPKBuilder of bundletool.
+type heapScalableTool struct {
+	bundletool.Tool
+}
+
+// WithLargerHeap returns the tool with a doubled maximum heap size, see bundletool.Tool.WithLargerHeap.
+func (tool heapScalableTool) WithLargerHeap() APKBuilder {
+	return heapScalableTool{Tool: tool.Tool.WithLargerHeap()}
+}
+
+// heapScalable returns the builder as a HeapScalableAPKBuilder, if it can be recreated with a larger JVM heap.
+func heapScalable(builder APKBuilder) (HeapScalableAPKBuilder, bool) {
+	switch builder := builder.(type) {
+	case HeapScalableAPKBuilder:
+		return builder, true
+	case *bundletool.Tool:
+		return heapScalableTool{Tool: *builder}, true
+	case bundletool.Tool:
+		return heapScalableTool{Tool: builder}, true
+	default:
+		return nil, false
+	}
 }
 
 // FileDownloader represents a type that can download a file.
@@ -33,31 +70,152 @@ type FileDownloader interface {
 	Get(destination, source string) error
 }
 
+// SecretRegistry represents a type masking secrets in the log, for example the detected keystore alias.
+type SecretRegistry interface {
+	Add(secrets ...string)
+}
+
+// Workspace represents a type providing private temporary directories.
+type Workspace interface {
+	Dir(pattern string) (string, error)
+	SensitiveDir(pattern string) (string, error)
+}
+
 // Exporter can be used to export an universal APK from AAB.
 type Exporter struct {
-	apkBuilder     APKBuilder
-	filedownloader FileDownloader
+	apkBuilder        APKBuilder
+	filedownloader    FileDownloader
+	keystoreValidator KeystoreValidator
+	workspace         Workspace
+	secrets           SecretRegistry
+
+	retryOnOutOfMemory bool
+	reuseExisting      bool
+	apkNameTemplate    *APKNameTemplate
 }
 
 // New creates a new Exporter.
 func New(apkBuilder APKBuilder, filedownloader FileDownloader) Exporter {
 	return Exporter{
-		apkBuilder:     apkBuilder,
-		filedownloader: filedownloader,
+		apkBuilder:        apkBuilder,
+		filedownloader:    filedownloader,
+		keystoreValidator: nativeKeystoreValidator{},
+	}
+}
+
+// WithOutOfMemoryRetry returns a copy of the exporter which retries building the APKs with a larger JVM heap
+// if bundletool runs out of memory.
+func (exporter Exporter) WithOutOfMemoryRetry() Exporter {
+	exporter.retryOnOutOfMemory = true
+	return exporter
+}
+
+// WithReuseExisting returns a copy of the exporter which reuses the universal APK of the same variant in the destination dir,
+// if it was exported from the same bundle. The digest of the bundle is embedded into the exported APKs to tell this.
+func (exporter Exporter) WithReuseExisting() Exporter {
+	exporter.reuseExisting = true
+	return exporter
+}
+
+// WithWorkspace returns a copy of the exporter which creates its temporary directories in the workspace.
+// Keystores are downloaded into sensitive directories, the workspace is responsible for removing them.
+func (exporter Exporter) WithWorkspace(workspace Workspace) Exporter {
+	exporter.workspace = workspace
+	return exporter
+}
+
+// WithSecrets returns a copy of the exporter which registers the secrets it finds out, like the detected keystore alias.
+func (exporter Exporter) WithSecrets(secrets SecretRegistry) Exporter {
+	exporter.secrets = secrets
+	return exporter
+}
+
+// tempDir creates a temporary directory in the workspace if set, otherwise in the OS temp dir.
+func (exporter Exporter) tempDir(pattern string, sensitive bool) (string, error) {
+	switch {
+	case exporter.workspace == nil:
+		return pathutil.NormalizedOSTempDirPath(pattern)
+	case sensitive:
+		return exporter.workspace.SensitiveDir(pattern)
+	default:
+		return exporter.workspace.Dir(pattern)
 	}
 }
 
-// unzipAPKsArchive unzips an universal apks archive.
+// removeTempDir removes an export's intermediate files, like the .apks archive and its extracted content.
+func removeTempDir(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		log.Warnf("Failed to remove temporary directory (%s): %s", dir, err)
+	}
+}
+
+// unzipAPKsArchive extracts the universal apk from an universal apks archive.
+// The apk is located using the archive's table of contents, falling back to the universal.apk name.
 func unzipAPKsArchive(archive, destDir string) (string, error) {
-	if err := run(command.New("unzip", archive, "-d", destDir)); err != nil {
-		return "", err
+	entryName := universalAPKName
+
+	toc, err := bundletool.ReadTableOfContents(archive)
+	if err != nil {
+		log.Warnf("Failed to read table of contents of %s, looking for %s: %s", archive, universalAPKName, err)
+	} else {
+		apks := toc.StandaloneAPKs()
+		if len(apks) != 1 {
+			return "", fmt.Errorf("expected exactly one standalone APK in %s, found: %d", archive, len(apks))
+		}
+
+		apk := apks[0]
+		log.Printf("Universal APK: %s (modules: %s, targeting: %s)", apk.Path, strings.Join(apk.FusedModules, ", "), apk.Targeting)
+		entryName = apk.Path
+	}
+
+	return extractArchiveEntry(archive, entryName, destDir)
+}
+
+// collectAPKs returns the APKs extracted into a directory, sorted by path.
+func collectAPKs(dir string) ([]string, error) {
+	var pths []string
+	if err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(pth) == apkExtension {
+			pths = append(pths, pth)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if len(pths) == 0 {
+		return nil, fmt.Errorf("no APK found in: %s", dir)
 	}
+	sort.Strings(pths)
+	return pths, nil
+}
 
-	pth := filepath.Join(destDir, "universal.apk")
-	if _, err := os.Stat(pth); os.IsNotExist(err) {
-		return "", os.ErrNotExist
+// copyFile copies an exported file to its destination.
+func copyFile(source, destination string) error {
+	r, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Warnf("Failed to close file (%s): %s", source, err)
+		}
+	}()
+
+	w, err := os.OpenFile(destination, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		if closeErr := w.Close(); closeErr != nil {
+			log.Warnf("Failed to close file (%s): %s", destination, closeErr)
+		}
+		return err
 	}
-	return pth, nil
+	return w.Close()
 }
 
 // handleError creates error with layout: `<cmd> failed (status: <status_code>): <cmd output>`.
@@ -84,60 +242,123 @@ func run(cmd *command.Model) error {
 
 // ExportUniversalAPK generates a universal apk from an aab file.
 func (exporter Exporter) ExportUniversalAPK(aabPath, destDir string, keystoreConfig *bundletool.KeystoreConfig) (string, error) {
-	tempPath, err := pathutil.NormalizedOSTempDirPath("universal_apk")
+	keystoreConfig, err := exporter.prepareKeystoreConfig(keystoreConfig)
 	if err != nil {
 		return "", err
 	}
 
-	keystoreConfig, err = exporter.prepareKeystoreConfig(keystoreConfig)
+	apkName, err := exporter.universalAPKName(aabPath, keystoreConfig != nil)
 	if err != nil {
 		return "", err
 	}
 
+	apkPath, _, err := exporter.exportUniversalAPK(aabPath, destDir, apkName, keystoreConfig)
+	return apkPath, err
+}
+
+// exportUniversalAPK generates a universal apk named apkName from an aab file with an already prepared keystore config.
+// Returns true if an existing apk is reused.
+func (exporter Exporter) exportUniversalAPK(aabPath, destDir, apkName string, keystoreConfig *bundletool.KeystoreConfig) (string, bool, error) {
+	destinationPath := filepath.Join(destDir, apkName)
+
+	var aabDigest []byte
+	if exporter.reuseExisting {
+		var err error
+		if aabDigest, err = fileSHA256(aabPath); err != nil {
+			return "", false, err
+		}
+
+		existingPath := variantUniversalAPK(aabPath, destDir)
+		if exporter.apkNameTemplate != nil {
+			existingPath = ""
+			if _, err := os.Stat(destinationPath); err == nil {
+				existingPath = destinationPath
+			}
+		}
+		if existingPath != "" && exporter.isReusableAPK(existingPath, aabDigest, keystoreConfig) {
+			log.Donef("Reusing %s, it was exported from the same bundle", existingPath)
+			return existingPath, true, nil
+		}
+	}
+
+	tempPath, err := exporter.tempDir("universal_apk", false)
+	if err != nil {
+		return "", false, err
+	}
+	defer removeTempDir(tempPath)
+
 	apksPath, err := exporter.exportAPKs(aabPath, tempPath, keystoreConfig)
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 
 	universalAPKPath, err := unzipAPKsArchive(apksPath, tempPath)
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 
-	universalAPKName := UniversalAPKBase(aabPath)
-	destinationPath := filepath.Join(destDir, universalAPKName)
-	if err := command.CopyFile(universalAPKPath, destinationPath); err != nil {
-		return "", err
+	if aabDigest != nil {
+		if err := apksignature.EmbedBundleDigest(universalAPKPath, aabDigest); err != nil {
+			log.Warnf("Failed to embed the bundle digest, %s can not be reused by later runs: %s", destinationPath, err)
+		}
 	}
 
-	return destinationPath, nil
+	if err := copyFile(universalAPKPath, destinationPath); err != nil {
+		return "", false, err
+	}
+
+	return destinationPath, false, nil
 }
 
-// Prepares the KeystoreConfig for use. For example: download the keystore file or prefix passwords.
+// Prepares the KeystoreConfig for use. For example: download the keystore file, validate it or write the passwords to files.
 func (exporter Exporter) prepareKeystoreConfig(keystoreConfig *bundletool.KeystoreConfig) (*bundletool.KeystoreConfig, error) {
 	if keystoreConfig == nil {
 		// No KeystoreConfig passed, nothing to prepare
 		return nil, nil
 	}
 
-	keystoreConfig, err := exporter.prepareKeystorePath(keystoreConfig)
+	tmpDir, err := exporter.tempDir("keystore", true)
 	if err != nil {
 		return nil, err
 	}
 
-	exporter.prepareKeystoreConfigPasswords(keystoreConfig)
+	keystoreConfig, err = exporter.prepareKeystorePath(keystoreConfig, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if keystoreConfig.SigningKeyAlias == "" {
+		if exporter.keystoreValidator == nil {
+			return nil, errors.New("keystore alias is not set")
+		}
+		alias, err := exporter.keystoreValidator.SigningKeyAlias(*keystoreConfig)
+		if err != nil {
+			return nil, err
+		}
+		if exporter.secrets != nil {
+			exporter.secrets.Add(alias)
+		}
+		log.Infof("Keystore alias is not set, using the keystore's only private key")
+		keystoreConfig.SigningKeyAlias = alias
+	}
+
+	if exporter.keystoreValidator != nil {
+		if err := exporter.keystoreValidator.Validate(*keystoreConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := prepareKeystoreConfigPasswords(keystoreConfig, tmpDir); err != nil {
+		return nil, err
+	}
 	return keystoreConfig, nil
 }
 
 // Prepares the keystore path for use. This could either mean:
 // - If a web url is provided, it downloads the keystore
 // - If a file url is provided, it trims the prefix of the path
-func (exporter Exporter) prepareKeystorePath(keystoreConfig *bundletool.KeystoreConfig) (*bundletool.KeystoreConfig, error) {
-	tmpDir, err := pathutil.NormalizedOSTempDirPath("keystore")
-	if err != nil {
-		return nil, err
-	}
-
+func (exporter Exporter) prepareKeystorePath(keystoreConfig *bundletool.KeystoreConfig, tmpDir string) (*bundletool.KeystoreConfig, error) {
+	var err error
 	keystorePath := ""
 	if strings.HasPrefix(keystoreConfig.Path, fileSchema) {
 		keystorePath, err = trimmedFilePath(keystoreConfig.Path)
@@ -155,6 +376,9 @@ func (exporter Exporter) prepareKeystorePath(keystoreConfig *bundletool.Keystore
 		if err := exporter.filedownloader.Get(keystorePath, keystoreConfig.Path); err != nil {
 			return nil, err
 		}
+		if err := workspace.RestrictPermissions(keystorePath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
 	}
 	log.Infof("Using keystore at: %s", keystorePath)
 
@@ -180,28 +404,144 @@ func keystoreNameFromURL(path string) (string, error) {
 	return filepath.Base(url.Path), nil
 }
 
-// Prefix passwords.
-func (exporter Exporter) prepareKeystoreConfigPasswords(keystoreConfig *bundletool.KeystoreConfig) {
-	keystoreConfig.KeystorePassword = prefixWithPass(keystoreConfig.KeystorePassword)
-	keystoreConfig.SigningKeyPassword = prefixWithPass(keystoreConfig.SigningKeyPassword)
+// prepareKeystoreConfigPasswords converts the passwords to bundletool's file: form (see bundletool.PasswordSource.Arg),
+// so that they do not show up on the command line. The passwords are interpreted by bundletool.PasswordSourceFromArg.
+func prepareKeystoreConfigPasswords(keystoreConfig *bundletool.KeystoreConfig, tmpDir string) error {
+	keystorePassword, err := bundletool.PasswordSourceFromArg(keystoreConfig.KeystorePassword).Arg(nil, tmpDir, "keystore_password")
+	if err != nil {
+		return fmt.Errorf("keystore password: %w", err)
+	}
+	keyPassword, err := bundletool.PasswordSourceFromArg(keystoreConfig.SigningKeyPassword).Arg(nil, tmpDir, "private_key_password")
+	if err != nil {
+		return fmt.Errorf("private key password: %w", err)
+	}
+	keystoreConfig.KeystorePassword = keystorePassword
+	keystoreConfig.SigningKeyPassword = keyPassword
+	return nil
+}
+
+// ExportAPKSet generates an APK set archive (.apks) from an aab file, according to the given build-apks options.
+func (exporter Exporter) ExportAPKSet(aabPath, destDir string, opts bundletool.BuildAPKsOptions, keystoreConfig *bundletool.KeystoreConfig) (string, error) {
+	tempPath, err := exporter.tempDir("apk_set", false)
+	if err != nil {
+		return "", err
+	}
+	defer removeTempDir(tempPath)
+
+	keystoreConfig, err = exporter.prepareKeystoreConfig(keystoreConfig)
+	if err != nil {
+		return "", err
+	}
+
+	apksPath, err := exporter.exportAPKsWithOptions(aabPath, tempPath, opts, keystoreConfig)
+	if err != nil {
+		return "", err
+	}
+
+	destinationPath := filepath.Join(destDir, apksFilename(aabPath))
+	if err := copyFile(apksPath, destinationPath); err != nil {
+		return "", err
+	}
+
+	return destinationPath, nil
+}
+
+// ExportDeviceAPKs generates the set of APKs a device matching the given device spec would receive from Play.
+func (exporter Exporter) ExportDeviceAPKs(aabPath, destDir, deviceSpecPath string, keystoreConfig *bundletool.KeystoreConfig) ([]string, error) {
+	tempPath, err := exporter.tempDir("device_apks", false)
+	if err != nil {
+		return nil, err
+	}
+	defer removeTempDir(tempPath)
+
+	keystoreConfig, err = exporter.prepareKeystoreConfig(keystoreConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	apksPath, err := exporter.exportAPKsWithOptions(aabPath, tempPath, bundletool.BuildAPKsOptions{Mode: bundletool.ModeDefault}, keystoreConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	extractedDir := filepath.Join(tempPath, "extracted")
+	if err := os.MkdirAll(extractedDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := run(exporter.apkBuilder.ExtractAPKs(apksPath, extractedDir, deviceSpecPath)); err != nil {
+		return nil, err
+	}
+
+	extractedAPKs, err := collectAPKs(extractedDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var destinationPaths []string
+	for _, extractedAPK := range extractedAPKs {
+		destinationPath := filepath.Join(destDir, deviceAPKFilename(aabPath, extractedAPK))
+		if err := copyFile(extractedAPK, destinationPath); err != nil {
+			return nil, err
+		}
+		destinationPaths = append(destinationPaths, destinationPath)
+	}
+
+	return destinationPaths, nil
 }
 
 func (exporter Exporter) exportAPKs(aabPath, tempPath string, keystoreConfig *bundletool.KeystoreConfig) (string, error) {
 	apksPath := filepath.Join(tempPath, apksFilename(aabPath))
 
-	buildAPKsCommand := exporter.apkBuilder.BuildAPKs(aabPath, apksPath, keystoreConfig)
-	err := run(buildAPKsCommand)
-	if err != nil {
+	if err := exporter.buildAPKs(apksPath, func(builder APKBuilder) *command.Model {
+		return builder.BuildAPKs(aabPath, apksPath, keystoreConfig)
+	}); err != nil {
 		return "", err
 	}
 
 	return apksPath, nil
 }
 
+func (exporter Exporter) exportAPKsWithOptions(aabPath, tempPath string, opts bundletool.BuildAPKsOptions, keystoreConfig *bundletool.KeystoreConfig) (string, error) {
+	apksPath := filepath.Join(tempPath, apksFilename(aabPath))
+
+	if err := exporter.buildAPKs(apksPath, func(builder APKBuilder) *command.Model {
+		return builder.BuildAPKsWithOptions(aabPath, apksPath, opts, keystoreConfig)
+	}); err != nil {
+		return "", err
+	}
+
+	return apksPath, nil
+}
+
+// buildAPKs runs the build-apks command created by the given function,
+// retrying it with a larger JVM heap on OutOfMemoryError if enabled.
+func (exporter Exporter) buildAPKs(apksPath string, buildCommand func(builder APKBuilder) *command.Model) error {
+	err := run(buildCommand(exporter.apkBuilder))
+	if err == nil || !exporter.retryOnOutOfMemory || !strings.Contains(err.Error(), outOfMemoryError) {
+		return err
+	}
+
+	scalable, ok := heapScalable(exporter.apkBuilder)
+	if !ok {
+		return err
+	}
+
+	log.Warnf("bundletool ran out of memory, retrying with a larger heap")
+	if err := os.RemoveAll(apksPath); err != nil {
+		return err
+	}
+	return run(buildCommand(scalable.WithLargerHeap()))
+}
+
 func apksFilename(aabPath string) string {
 	return filenameWithExtension(aabPath, apksExtension)
 }
 
+// deviceAPKFilename prefixes an extracted APK's name (for example base-master.apk) with the aab's name.
+func deviceAPKFilename(aabPath, extractedAPKPath string) string {
+	return filenameWithExtension(aabPath, "") + "-" + filepath.Base(extractedAPKPath)
+}
+
 func apkFilename(apksPath string) string {
 	return filenameWithExtension(apksPath, apkExtension)
 }
@@ -211,10 +551,3 @@ func filenameWithExtension(basepath, extension string) string {
 	fileNameWithoutExtension := strings.TrimSuffix(filename, filepath.Ext(filename))
 	return fileNameWithoutExtension + extension
 }
-
-func prefixWithPass(s string) string {
-	if !strings.HasPrefix(s, passPrefix) {
-		return passPrefix + s
-	}
-	return s
-}
@@ -1,8 +1,11 @@
 package apkexporter
 
 import (
+	"crypto/x509"
 	"errors"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/bitrise-io/go-utils/command"
@@ -43,6 +46,67 @@ func Test_exportAPKs_FaillingCommand(t *testing.T) {
 	require.Empty(t, output)
 }
 
+func Test_exportAPKs_OutOfMemoryRetry(t *testing.T) {
+	// Given
+	mockAPKBuilder := &MockHeapScalableAPKBuilder{MockAPKBuilder: *givenMockedAPKBuilder(givenOutOfMemoryCommand())}
+	exporter := givenExporter(mockAPKBuilder, givenMockFileDownloader()).WithOutOfMemoryRetry()
+
+	// When
+	output, err := exporter.exportAPKs("/path/to/app.aab", "/temp/path", nil)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "/temp/path/app.apks", output)
+	require.True(t, mockAPKBuilder.scaled)
+}
+
+func Test_exportAPKs_OutOfMemoryWithoutRetry(t *testing.T) {
+	// Given
+	mockAPKBuilder := &MockHeapScalableAPKBuilder{MockAPKBuilder: *givenMockedAPKBuilder(givenOutOfMemoryCommand())}
+	exporter := givenExporter(mockAPKBuilder, givenMockFileDownloader())
+
+	// When
+	_, err := exporter.exportAPKs("/path/to/app.aab", "/temp/path", nil)
+
+	// Then
+	require.Error(t, err)
+	require.False(t, mockAPKBuilder.scaled)
+}
+
+func Test_heapScalable(t *testing.T) {
+	// Given
+	t.Setenv("JAVA_TOOL_OPTIONS", "")
+	launcher, err := bundletool.NewLocal("true")
+	require.NoError(t, err)
+	tool := launcher.WithJVMOptions(bundletool.JVMOptions{MaxHeap: "2g"})
+
+	// When
+	scalable, ok := heapScalable(&tool)
+
+	// Then
+	require.True(t, ok)
+	cmd := scalable.WithLargerHeap().BuildAPKs("app.aab", "app.apks", nil)
+	require.Contains(t, cmd.GetCmd().Env, "JAVA_TOOL_OPTIONS=-Xmx4g")
+
+	_, ok = heapScalable(givenMockedAPKBuilder(givenSuccessfulCommand()))
+	require.False(t, ok)
+}
+
+func Test_exportAPKsWithOptions_Successful(t *testing.T) {
+	// Given
+	mockAPKBuilder := givenMockedAPKBuilder(givenSuccessfulCommand())
+	exporter := givenExporter(mockAPKBuilder, givenMockFileDownloader())
+	opts := bundletool.BuildAPKsOptions{Mode: bundletool.ModeDefault, Modules: []string{"base"}}
+
+	// When
+	output, err := exporter.exportAPKsWithOptions("/path/to/app.aab", "/temp/path", opts, nil)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "/temp/path/app.apks", output)
+	mockAPKBuilder.AssertCalled(t, "BuildAPKsWithOptions", "/path/to/app.aab", "/temp/path/app.apks", opts, (*bundletool.KeystoreConfig)(nil))
+}
+
 func Test_prepareKeystoreConfig_File(t *testing.T) {
 	// Given
 	mockAPKBuilder := givenMockedAPKBuilder(givenSuccessfulCommand())
@@ -58,8 +122,8 @@ func Test_prepareKeystoreConfig_File(t *testing.T) {
 	// Then
 	require.NoError(t, err)
 	require.Equal(t, output.Path, "/keystore.jks")
-	require.Equal(t, output.KeystorePassword, "pass:password")
-	require.Equal(t, output.SigningKeyPassword, "pass:password")
+	assertPasswordFile(t, output.KeystorePassword, "password")
+	assertPasswordFile(t, output.SigningKeyPassword, "password")
 }
 
 func Test_prepareKeystoreConfig_SuccessDownload(t *testing.T) {
@@ -74,8 +138,8 @@ func Test_prepareKeystoreConfig_SuccessDownload(t *testing.T) {
 	// Then
 	require.NoError(t, err)
 	require.Equal(t, filepath.Base(output.Path), "keystore.jks")
-	require.Equal(t, output.KeystorePassword, "pass:password")
-	require.Equal(t, output.SigningKeyPassword, "pass:password")
+	assertPasswordFile(t, output.KeystorePassword, "password")
+	assertPasswordFile(t, output.SigningKeyPassword, "password")
 }
 
 func Test_prepareKeystoreConfig_FaillingDownload(t *testing.T) {
@@ -93,6 +157,149 @@ func Test_prepareKeystoreConfig_FaillingDownload(t *testing.T) {
 	require.Nil(t, output)
 }
 
+func Test_prepareKeystoreConfig_Validation(t *testing.T) {
+	// Given
+	mockKeystoreValidator := new(MockKeystoreValidator)
+	mockKeystoreValidator.On("Validate", mock.Anything).Return(errors.New("invalid keystore: keystore password is incorrect"))
+	exporter := givenExporter(givenMockedAPKBuilder(givenSuccessfulCommand()), givenMockFileDownloader())
+	exporter.keystoreValidator = mockKeystoreValidator
+
+	// When
+	output, err := exporter.prepareKeystoreConfig(givenKeystoreConfig("file:///keystore.jks"))
+
+	// Then
+	require.EqualError(t, err, "invalid keystore: keystore password is incorrect")
+	require.Nil(t, output)
+	mockKeystoreValidator.AssertCalled(t, "Validate", bundletool.KeystoreConfig{
+		Path:               "/keystore.jks",
+		KeystorePassword:   "password",
+		SigningKeyAlias:    "alias",
+		SigningKeyPassword: "password",
+	})
+}
+
+func Test_prepareKeystoreConfig_DetectAlias(t *testing.T) {
+	// Given
+	mockKeystoreValidator := new(MockKeystoreValidator)
+	mockKeystoreValidator.On("SigningKeyAlias", mock.Anything).Return("release", nil)
+	mockKeystoreValidator.On("Validate", mock.Anything).Return(nil)
+	exporter := givenExporter(givenMockedAPKBuilder(givenSuccessfulCommand()), givenMockFileDownloader())
+	exporter.keystoreValidator = mockKeystoreValidator
+	keystoreConfig := givenKeystoreConfig("file:///keystore.jks")
+	keystoreConfig.SigningKeyAlias = ""
+
+	// When
+	output, err := exporter.prepareKeystoreConfig(keystoreConfig)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "release", output.SigningKeyAlias)
+	mockKeystoreValidator.AssertCalled(t, "Validate", bundletool.KeystoreConfig{
+		Path:               "/keystore.jks",
+		KeystorePassword:   "password",
+		SigningKeyAlias:    "release",
+		SigningKeyPassword: "password",
+	})
+}
+
+func Test_prepareKeystoreConfig_DetectAlias_RegistersSecret(t *testing.T) {
+	// Given
+	mockKeystoreValidator := new(MockKeystoreValidator)
+	mockKeystoreValidator.On("SigningKeyAlias", mock.Anything).Return("release", nil)
+	mockKeystoreValidator.On("Validate", mock.Anything).Return(nil)
+	secrets := &secretRecorder{}
+	exporter := givenExporter(givenMockedAPKBuilder(givenSuccessfulCommand()), givenMockFileDownloader()).WithSecrets(secrets)
+	exporter.keystoreValidator = mockKeystoreValidator
+	keystoreConfig := givenKeystoreConfig("file:///keystore.jks")
+	keystoreConfig.SigningKeyAlias = ""
+
+	// When
+	_, err := exporter.prepareKeystoreConfig(keystoreConfig)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"release"}, secrets.secrets)
+}
+
+func Test_prepareKeystoreConfig_Workspace(t *testing.T) {
+	// Given
+	mockFileDownloader := new(MockFileDownloader)
+	mockFileDownloader.On("Get", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		require.NoError(t, os.WriteFile(args.String(0), []byte("keystore"), 0644))
+	})
+	ws := &fakeWorkspace{root: t.TempDir()}
+	exporter := givenExporter(givenMockedAPKBuilder(givenSuccessfulCommand()), mockFileDownloader).WithWorkspace(ws)
+
+	// When
+	output, err := exporter.prepareKeystoreConfig(givenKeystoreConfig("http://url.com/keystore.jks"))
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Dir(output.Path)}, ws.sensitiveDirs)
+	info, err := os.Stat(output.Path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func Test_prepareKeystoreConfigPasswords(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password.txt")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("file password\n"), 0600))
+	keystoreConfig := bundletool.KeystoreConfig{KeystorePassword: "pass:pass:word", SigningKeyPassword: "file:" + passwordFile}
+
+	// When
+	err := prepareKeystoreConfigPasswords(&keystoreConfig, dir)
+
+	// Then
+	require.NoError(t, err)
+	assertPasswordFile(t, keystoreConfig.KeystorePassword, "pass:word")
+	require.Equal(t, "file:"+passwordFile, keystoreConfig.SigningKeyPassword)
+}
+
+func assertPasswordFile(t *testing.T, arg, expectedPassword string) {
+	require.True(t, strings.HasPrefix(arg, "file:"), arg)
+	password, err := bundletool.PasswordFromArg(arg)
+	require.NoError(t, err)
+	require.Equal(t, expectedPassword, password)
+}
+
+func Test_collectAPKs(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	for _, name := range []string{"base-master.apk", "base-arm64_v8a.apk", "toc.pb", "splits/feature-master.apk"} {
+		pth := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755))
+		require.NoError(t, os.WriteFile(pth, []byte{}, 0644))
+	}
+
+	// When
+	apks, err := collectAPKs(dir)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "base-arm64_v8a.apk"),
+		filepath.Join(dir, "base-master.apk"),
+		filepath.Join(dir, "splits/feature-master.apk"),
+	}, apks)
+}
+
+func Test_collectAPKs_Empty(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+
+	// When
+	_, err := collectAPKs(dir)
+
+	// Then
+	require.EqualError(t, err, "no APK found in: "+dir)
+}
+
+func Test_deviceAPKFilename(t *testing.T) {
+	require.Equal(t, "app-release-base-master.apk", deviceAPKFilename("/path/to/app-release.aab", "/tmp/extracted/base-master.apk"))
+}
+
 func Test_apksFilename(t *testing.T) {
 	// Given
 	aabPath := "/path/to/app.aab"
@@ -200,7 +407,42 @@ func givenMockFailingFileDownloader(err error) *MockFileDownloader {
 }
 
 func givenExporter(apkbuilder APKBuilder, filedownloader FileDownloader) Exporter {
-	return Exporter{apkbuilder, filedownloader}
+	return Exporter{apkBuilder: apkbuilder, filedownloader: filedownloader}
+}
+
+type fakeWorkspace struct {
+	root          string
+	sensitiveDirs []string
+}
+
+func (ws *fakeWorkspace) Dir(pattern string) (string, error) {
+	return os.MkdirTemp(ws.root, pattern)
+}
+
+func (ws *fakeWorkspace) SensitiveDir(pattern string) (string, error) {
+	dir, err := ws.Dir(pattern)
+	ws.sensitiveDirs = append(ws.sensitiveDirs, dir)
+	return dir, err
+}
+
+type MockKeystoreValidator struct {
+	mock.Mock
+}
+
+func (m *MockKeystoreValidator) Validate(keystoreConfig bundletool.KeystoreConfig) error {
+	args := m.Called(keystoreConfig)
+	return args.Error(0)
+}
+
+func (m *MockKeystoreValidator) SigningKeyAlias(keystoreConfig bundletool.KeystoreConfig) (string, error) {
+	args := m.Called(keystoreConfig)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockKeystoreValidator) SigningCertificate(keystoreConfig bundletool.KeystoreConfig) (*x509.Certificate, error) {
+	args := m.Called(keystoreConfig)
+	certificate, _ := args.Get(0).(*x509.Certificate)
+	return certificate, args.Error(1)
 }
 
 type MockAPKBuilder struct {
@@ -212,12 +454,38 @@ func (m *MockAPKBuilder) BuildAPKs(aabPath, apksPath string, keystoreCfg *bundle
 	return args.Get(0).(*command.Model)
 }
 
+func (m *MockAPKBuilder) BuildAPKsWithOptions(aabPath, apksPath string, opts bundletool.BuildAPKsOptions, keystoreCfg *bundletool.KeystoreConfig) *command.Model {
+	args := m.Called(aabPath, apksPath, opts, keystoreCfg)
+	return args.Get(0).(*command.Model)
+}
+
+func (m *MockAPKBuilder) ExtractAPKs(apksPath, outputDir, deviceSpecPath string) *command.Model {
+	args := m.Called(apksPath, outputDir, deviceSpecPath)
+	return args.Get(0).(*command.Model)
+}
+
 func givenMockedAPKBuilder(cmd *command.Model) *MockAPKBuilder {
 	mockBundletooler := new(MockAPKBuilder)
+	mockBundletooler.On("ExtractAPKs", mock.Anything, mock.Anything, mock.Anything).Return(cmd)
 	mockBundletooler.On("BuildAPKs", mock.Anything, mock.Anything, mock.Anything).Return(cmd)
+	mockBundletooler.On("BuildAPKsWithOptions", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(cmd)
 	return mockBundletooler
 }
 
+type MockHeapScalableAPKBuilder struct {
+	MockAPKBuilder
+	scaled bool
+}
+
+func (m *MockHeapScalableAPKBuilder) WithLargerHeap() APKBuilder {
+	m.scaled = true
+	return givenMockedAPKBuilder(givenSuccessfulCommand())
+}
+
+func givenOutOfMemoryCommand() *command.Model {
+	return command.New("sh", "-c", "echo 'Exception in thread \"main\" java.lang.OutOfMemoryError: Java heap space'; exit 1")
+}
+
 func givenFailingCommand() *command.Model {
 	return command.New("this", "fails")
 }
@@ -233,3 +501,11 @@ func givenKeystoreConfig(path string) *bundletool.KeystoreConfig {
 		SigningKeyAlias:    "alias",
 		SigningKeyPassword: "password"}
 }
+
+type secretRecorder struct {
+	secrets []string
+}
+
+func (r *secretRecorder) Add(secrets ...string) {
+	r.secrets = append(r.secrets, secrets...)
+}
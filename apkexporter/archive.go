@@ -0,0 +1,117 @@
+package apkexporter
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const universalAPKName = "universal.apk"
+
+// extractArchiveEntry streams a single entry of a zip archive into destDir,
+// keeping its relative path, and returns the extracted file's path.
+func extractArchiveEntry(archive, name, destDir string) (string, error) {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive (%s): %w", archive, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Warnf("Failed to close archive (%s): %s", archive, err)
+		}
+	}()
+
+	var entryNames []string
+	for _, file := range reader.File {
+		if file.Name != name {
+			entryNames = append(entryNames, file.Name)
+			continue
+		}
+
+		destination, err := entryDestination(destDir, file.Name)
+		if err != nil {
+			return "", err
+		}
+		if err := extractFile(file, destination); err != nil {
+			return "", fmt.Errorf("failed to extract %s from archive (%s): %w", name, archive, err)
+		}
+		return destination, nil
+	}
+
+	return "", fmt.Errorf("%s not found in archive (%s), entries: %s", name, archive, strings.Join(entryNames, ", "))
+}
+
+// ExtractArchiveAPKs extracts every APK of an APK set archive into destDir, keeping their relative paths,
+// and returns the extracted APKs' paths in archive order.
+func ExtractArchiveAPKs(archive, destDir string) ([]string, error) {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive (%s): %w", archive, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Warnf("Failed to close archive (%s): %s", archive, err)
+		}
+	}()
+
+	var pths []string
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() || filepath.Ext(file.Name) != apkExtension {
+			continue
+		}
+
+		destination, err := entryDestination(destDir, file.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := extractFile(file, destination); err != nil {
+			return nil, fmt.Errorf("failed to extract %s from archive (%s): %w", file.Name, archive, err)
+		}
+		pths = append(pths, destination)
+	}
+	return pths, nil
+}
+
+// entryDestination returns where an archive entry has to be extracted,
+// refusing entries which would be written outside of destDir (zip slip).
+func entryDestination(destDir, name string) (string, error) {
+	destination := filepath.Join(destDir, name)
+	rel, err := filepath.Rel(destDir, destination)
+	if err != nil || filepath.IsAbs(name) || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("illegal archive entry path: %s", name)
+	}
+	return destination, nil
+}
+
+func extractFile(file *zip.File, destination string) error {
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+
+	r, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Warnf("Failed to close archive entry (%s): %s", file.Name, err)
+		}
+	}()
+
+	w, err := os.OpenFile(destination, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		if closeErr := w.Close(); closeErr != nil {
+			log.Warnf("Failed to close file (%s): %s", destination, closeErr)
+		}
+		return err
+	}
+	return w.Close()
+}
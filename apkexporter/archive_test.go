@@ -0,0 +1,169 @@
+package apkexporter
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_unzipAPKsArchive(t *testing.T) {
+	// Given
+	archive := givenArchive(t, map[string]string{
+		"toc.pb":        "toc",
+		"universal.apk": "apk content",
+	})
+	destDir := t.TempDir()
+
+	// When
+	pth, err := unzipAPKsArchive(archive, destDir)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(destDir, "universal.apk"), pth)
+	assertFileContent(t, pth, "apk content")
+	_, err = os.Stat(filepath.Join(destDir, "toc.pb"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func Test_unzipAPKsArchive_TableOfContents(t *testing.T) {
+	// Given
+	archive := givenArchive(t, map[string]string{
+		"toc.pb":                     string(givenTableOfContents("standalones/standalone.apk")),
+		"standalones/standalone.apk": "apk content",
+	})
+	destDir := t.TempDir()
+
+	// When
+	pth, err := unzipAPKsArchive(archive, destDir)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(destDir, "standalones/standalone.apk"), pth)
+	assertFileContent(t, pth, "apk content")
+}
+
+func Test_unzipAPKsArchive_NoStandaloneAPK(t *testing.T) {
+	// Given
+	archive := givenArchive(t, map[string]string{
+		"toc.pb":        "",
+		"universal.apk": "apk content",
+	})
+
+	// When
+	_, err := unzipAPKsArchive(archive, t.TempDir())
+
+	// Then
+	require.EqualError(t, err, "expected exactly one standalone APK in "+archive+", found: 0")
+}
+
+func Test_unzipAPKsArchive_MissingEntry(t *testing.T) {
+	// Given
+	archive := givenArchive(t, map[string]string{"toc.pb": "toc"})
+
+	// When
+	_, err := unzipAPKsArchive(archive, t.TempDir())
+
+	// Then
+	require.EqualError(t, err, "universal.apk not found in archive ("+archive+"), entries: toc.pb")
+}
+
+func Test_unzipAPKsArchive_InvalidArchive(t *testing.T) {
+	// Given
+	archive := filepath.Join(t.TempDir(), "app.apks")
+	require.NoError(t, os.WriteFile(archive, []byte("not a zip"), 0644))
+
+	// When
+	_, err := unzipAPKsArchive(archive, t.TempDir())
+
+	// Then
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to open archive")
+}
+
+func Test_extractArchiveEntry_ZipSlip(t *testing.T) {
+	// Given
+	archive := givenArchive(t, map[string]string{"../evil.apk": "evil"})
+	destDir := t.TempDir()
+
+	// When
+	_, err := extractArchiveEntry(archive, "../evil.apk", destDir)
+
+	// Then
+	require.EqualError(t, err, "illegal archive entry path: ../evil.apk")
+	_, err = os.Stat(filepath.Join(filepath.Dir(destDir), "evil.apk"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func Test_extractArchiveEntry_NestedEntry(t *testing.T) {
+	// Given
+	archive := givenArchive(t, map[string]string{"splits/base-master.apk": "master"})
+	destDir := t.TempDir()
+
+	// When
+	pth, err := extractArchiveEntry(archive, "splits/base-master.apk", destDir)
+
+	// Then
+	require.NoError(t, err)
+	assertFileContent(t, pth, "master")
+}
+
+func Test_ExtractArchiveAPKs(t *testing.T) {
+	// Given
+	archive := givenArchive(t, map[string]string{
+		"toc.pb":                 "toc",
+		"splits/base-master.apk": "master",
+		"splits/base-xxhdpi.apk": "xxhdpi",
+	})
+	destDir := t.TempDir()
+
+	// When
+	pths, err := ExtractArchiveAPKs(archive, destDir)
+
+	// Then
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{filepath.Join(destDir, "splits", "base-master.apk"), filepath.Join(destDir, "splits", "base-xxhdpi.apk")}, pths)
+	assertFileContent(t, filepath.Join(destDir, "splits", "base-xxhdpi.apk"), "xxhdpi")
+	require.NoFileExists(t, filepath.Join(destDir, "toc.pb"))
+}
+
+// givenTableOfContents returns a serialized BuildApksResult with a single standalone APK.
+func givenTableOfContents(apkPath string) []byte {
+	standaloneMetadata := protoField(4, protoField(1, []byte("base")))
+	apkDescription := protoField(2, append(protoField(2, []byte(apkPath)), standaloneMetadata...))
+	variant := protoField(2, apkDescription)
+	return protoField(1, variant)
+}
+
+// protoField encodes a length delimited protobuf field.
+func protoField(number int, value []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(number<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func givenArchive(t *testing.T, entries map[string]string) string {
+	pth := filepath.Join(t.TempDir(), "app.apks")
+	f, err := os.Create(pth)
+	require.NoError(t, err)
+
+	w := zip.NewWriter(f)
+	for name, content := range entries {
+		entry, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = entry.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+	return pth
+}
+
+func assertFileContent(t *testing.T, pth, expectedContent string) {
+	content, err := os.ReadFile(pth)
+	require.NoError(t, err)
+	require.Equal(t, expectedContent, string(content))
+}
@@ -0,0 +1,80 @@
+package apkexporter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/bundletool"
+)
+
+// UniversalAPKResult is the outcome of exporting the universal APK of a single bundle in a batch.
+type UniversalAPKResult struct {
+	AABPath  string
+	APKPath  string
+	Duration time.Duration
+	// Reused is true if an existing APK exported from the same bundle is reused, see Exporter.WithReuseExisting.
+	Reused bool
+	Err    error
+}
+
+// ExportUniversalAPKs generates a universal apk from each aab file, running at most workers exports at the same time.
+// The keystore is prepared once for the whole batch, and the APK names are resolved before exporting,
+// so that bundles exported to the same name are reported upfront. A failing export does not stop the others,
+// the results are returned in the order of aabPaths.
+func (exporter Exporter) ExportUniversalAPKs(aabPaths []string, destDir string, keystoreConfig *bundletool.KeystoreConfig, workers int) ([]UniversalAPKResult, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	keystoreConfig, err := exporter.prepareKeystoreConfig(keystoreConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	apkNames := make([]string, len(aabPaths))
+	for i, aabPath := range aabPaths {
+		if apkNames[i], err = exporter.universalAPKName(aabPath, keystoreConfig != nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkUniqueAPKNames(aabPaths, apkNames); err != nil {
+		return nil, err
+	}
+
+	results := make([]UniversalAPKResult, len(aabPaths))
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, aabPath := range aabPaths {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, aabPath string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			log.Printf("Exporting universal APK from: %s", aabPath)
+			start := time.Now()
+			apkPath, reused, err := exporter.exportUniversalAPK(aabPath, destDir, apkNames[i], keystoreConfig)
+			results[i] = UniversalAPKResult{AABPath: aabPath, APKPath: apkPath, Duration: time.Since(start), Reused: reused, Err: err}
+		}(i, aabPath)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// checkUniqueAPKNames fails if multiple bundles would be exported to the same APK name.
+func checkUniqueAPKNames(aabPaths, apkNames []string) error {
+	bundleByAPKName := map[string]string{}
+	for i, aabPath := range aabPaths {
+		name := apkNames[i]
+		if other, ok := bundleByAPKName[name]; ok {
+			return fmt.Errorf("bundles %s and %s would both be exported to %s", other, aabPath, name)
+		}
+		bundleByAPKName[name] = aabPath
+	}
+	return nil
+}
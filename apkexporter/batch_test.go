@@ -0,0 +1,156 @@
+package apkexporter
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/bundletool"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExportUniversalAPKs_Successful(t *testing.T) {
+	// Given
+	aabPaths := []string{"/path/to/app-free-release.aab", "/path/to/app-paid-release.aab", "/path/to/app-demo-release.aab"}
+	apkBuilder := givenBatchAPKBuilder(t, aabPaths)
+	exporter := givenExporter(apkBuilder, givenMockFileDownloader())
+	destDir := t.TempDir()
+
+	// When
+	results, err := exporter.ExportUniversalAPKs(aabPaths, destDir, nil, 2)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, results, len(aabPaths))
+	for i, result := range results {
+		require.NoError(t, result.Err)
+		require.Equal(t, aabPaths[i], result.AABPath)
+		require.Equal(t, filepath.Join(destDir, UniversalAPKBase(aabPaths[i])), result.APKPath)
+		require.False(t, result.Reused)
+		assertFileContent(t, result.APKPath, aabPaths[i])
+	}
+}
+
+func Test_ExportUniversalAPKs_Workers(t *testing.T) {
+	// Given
+	const workers = 2
+	aabPaths := []string{"/path/to/app-free-release.aab", "/path/to/app-paid-release.aab", "/path/to/app-demo-release.aab", "/path/to/app-beta-release.aab", "/path/to/app-alpha-release.aab"}
+	apkBuilder := givenBatchAPKBuilder(t, aabPaths)
+	apkBuilder.started = make(chan string, len(aabPaths))
+	apkBuilder.release = make(chan struct{})
+	exporter := givenExporter(apkBuilder, givenMockFileDownloader())
+
+	// When
+	type export struct {
+		results []UniversalAPKResult
+		err     error
+	}
+	done := make(chan export, 1)
+	go func() {
+		results, err := exporter.ExportUniversalAPKs(aabPaths, t.TempDir(), nil, workers)
+		done <- export{results: results, err: err}
+	}()
+
+	// Then
+	for i := 0; i < workers; i++ {
+		select {
+		case <-apkBuilder.started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d of %d workers started", i, workers)
+		}
+	}
+	select {
+	case aabPath := <-apkBuilder.started:
+		t.Fatalf("build of %s started while %d builds were running", aabPath, workers)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(apkBuilder.release)
+	exported := <-done
+	require.NoError(t, exported.err)
+	require.Len(t, exported.results, len(aabPaths))
+	for _, result := range exported.results {
+		require.NoError(t, result.Err)
+	}
+	require.Len(t, apkBuilder.started, len(aabPaths)-workers)
+}
+
+func Test_ExportUniversalAPKs_FailingExports(t *testing.T) {
+	// Given
+	aabPaths := []string{"/path/to/app-free-release.aab", "/path/to/app-paid-release.aab", "/path/to/app-demo-release.aab"}
+	apkBuilder := givenBatchAPKBuilder(t, nil)
+	apkBuilder.started = make(chan string, len(aabPaths))
+	exporter := givenExporter(apkBuilder, givenMockFileDownloader())
+
+	// When
+	results, err := exporter.ExportUniversalAPKs(aabPaths, t.TempDir(), nil, 2)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, results, len(aabPaths))
+	for i, result := range results {
+		require.Equal(t, aabPaths[i], result.AABPath)
+		require.Empty(t, result.APKPath)
+		require.Error(t, result.Err)
+	}
+	close(apkBuilder.started)
+	var built []string
+	for aabPath := range apkBuilder.started {
+		built = append(built, aabPath)
+	}
+	require.ElementsMatch(t, aabPaths, built)
+}
+
+func Test_ExportUniversalAPKs_DuplicateAPKName(t *testing.T) {
+	// Given
+	mockAPKBuilder := givenMockedAPKBuilder(givenSuccessfulCommand())
+	exporter := givenExporter(mockAPKBuilder, givenMockFileDownloader())
+
+	// When
+	results, err := exporter.ExportUniversalAPKs([]string{"/free/app-release.aab", "/paid/app-release.aab"}, t.TempDir(), nil, 2)
+
+	// Then
+	require.EqualError(t, err, "bundles /free/app-release.aab and /paid/app-release.aab would both be exported to app-universal-release.apk")
+	require.Nil(t, results)
+	mockAPKBuilder.AssertNotCalled(t, "BuildAPKs", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// batchAPKBuilder writes an APK archive per bundle, with the bundle's path as the universal APK's content.
+// If set, each build is reported on started and blocks until release is closed. Bundles without an archive fail to build.
+type batchAPKBuilder struct {
+	MockAPKBuilder
+	archives map[string]string
+	started  chan string
+	release  chan struct{}
+}
+
+func (b *batchAPKBuilder) BuildAPKs(aabPath, apksPath string, _ *bundletool.KeystoreConfig) *command.Model {
+	if b.started != nil {
+		b.started <- aabPath
+	}
+	if b.release != nil {
+		<-b.release
+	}
+
+	archive, ok := b.archives[aabPath]
+	if !ok {
+		return givenFailingCommand()
+	}
+	if err := copyFile(archive, apksPath); err != nil {
+		return givenFailingCommand()
+	}
+	return givenSuccessfulCommand()
+}
+
+func givenBatchAPKBuilder(t *testing.T, aabPaths []string) *batchAPKBuilder {
+	archives := map[string]string{}
+	for _, aabPath := range aabPaths {
+		archives[aabPath] = givenArchive(t, map[string]string{
+			universalAPKName:               aabPath,
+			bundletool.TableOfContentsName: string(givenTableOfContents(universalAPKName)),
+		})
+	}
+	return &batchAPKBuilder{archives: archives}
+}
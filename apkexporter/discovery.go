@@ -0,0 +1,142 @@
+package apkexporter
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const aabExtension = ".aab"
+
+// Variant identifies a build variant of a module.
+type Variant struct {
+	Module         string
+	ProductFlavour string
+	BuildType      string
+}
+
+// String returns the variant in <module>:<variant name> form, for example app:minApi21DemoRelease.
+func (variant Variant) String() string {
+	name := variant.BuildType
+	if variant.ProductFlavour != "" {
+		name = variantFlavourName(variant.ProductFlavour) + firstLetterUpper(variant.BuildType)
+	}
+	if variant.Module == "" {
+		return name
+	}
+	return variant.Module + ":" + name
+}
+
+// VariantFilter selects build variants by build type and product flavour, matched case insensitively.
+// Empty include lists select every variant. A multi dimension flavour (for example minApi21-demo) matches
+// if the whole flavour or any of its dimensions is listed.
+type VariantFilter struct {
+	IncludeBuildTypes []string
+	ExcludeBuildTypes []string
+	IncludeFlavours   []string
+	ExcludeFlavours   []string
+}
+
+// Match tells if the variant is selected by the filter.
+func (filter VariantFilter) Match(variant Variant) bool {
+	buildType := []string{variant.BuildType}
+	flavours := []string{variant.ProductFlavour}
+	if strings.Contains(variant.ProductFlavour, "-") {
+		flavours = append(flavours, strings.Split(variant.ProductFlavour, "-")...)
+	}
+
+	if len(filter.IncludeBuildTypes) > 0 && !containsAnyFold(filter.IncludeBuildTypes, buildType) {
+		return false
+	}
+	if len(filter.IncludeFlavours) > 0 && !containsAnyFold(filter.IncludeFlavours, flavours) {
+		return false
+	}
+	return !containsAnyFold(filter.ExcludeBuildTypes, buildType) && !containsAnyFold(filter.ExcludeFlavours, flavours)
+}
+
+// VariantAAB is a discovered bundle of a build variant.
+type VariantAAB struct {
+	Variant Variant
+	AABPath string
+}
+
+// FindAABs returns the .aab files matching the glob pattern, or the ones directly in dir if the pattern is empty.
+func FindAABs(dir, pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = filepath.Join(dir, "*"+aabExtension)
+	}
+	pths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern (%s): %w", pattern, err)
+	}
+
+	var aabPaths []string
+	for _, pth := range pths {
+		if filepath.Ext(pth) == aabExtension {
+			aabPaths = append(aabPaths, pth)
+		}
+	}
+	sort.Strings(aabPaths)
+	return aabPaths, nil
+}
+
+// SelectAABs groups the bundles by module, build type and product flavour, and returns a bundle
+// for each variant matching the filter, sorted by variant. If both the signed and the -unsigned or
+// -bitrise-signed bundle of a variant is listed, the signed one is selected.
+func SelectAABs(aabPaths []string, filter VariantFilter) []VariantAAB {
+	var selected []VariantAAB
+	for module, moduleArtifacts := range mapBuildArtifacts(withoutSigningVariants(aabPaths)) {
+		for buildType, buildTypeArtifacts := range moduleArtifacts {
+			for flavour, artifact := range buildTypeArtifacts {
+				variant := Variant{Module: module, ProductFlavour: flavour, BuildType: buildType}
+				if artifact.AAB == "" || !filter.Match(variant) {
+					continue
+				}
+				selected = append(selected, VariantAAB{Variant: variant, AABPath: artifact.AAB})
+			}
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].Variant.String() < selected[j].Variant.String()
+	})
+	return selected
+}
+
+// withoutSigningVariants drops the -unsigned and -bitrise-signed artifacts whose signed pair is also listed.
+func withoutSigningVariants(pths []string) []string {
+	var filtered []string
+	for _, pth := range pths {
+		info, base := parseSigningInfo(pth)
+		if info.Unsigned || info.BitriseSigned {
+			signedPth := filepath.Join(filepath.Dir(pth), base+filepath.Ext(pth))
+			if FindSameArtifact(signedPth, pths) == signedPth {
+				continue
+			}
+		}
+		filtered = append(filtered, pth)
+	}
+	return filtered
+}
+
+// variantFlavourName joins the dimensions of a multi dimension flavour as the Android Gradle Plugin does in the
+// variant name, for example minApi21-demo becomes minApi21Demo.
+func variantFlavourName(flavour string) string {
+	dimensions := strings.Split(flavour, "-")
+	for i := 1; i < len(dimensions); i++ {
+		dimensions[i] = firstLetterUpper(dimensions[i])
+	}
+	return strings.Join(dimensions, "")
+}
+
+func containsAnyFold(list, values []string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if strings.EqualFold(item, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
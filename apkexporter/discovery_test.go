@@ -0,0 +1,62 @@
+package apkexporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FindAABs(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	for _, name := range []string{"app-release.aab", "app-debug.aab", "app-release.apk", "mapping.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+
+	// When
+	aabPaths, err := FindAABs(dir, "")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "app-debug.aab"), filepath.Join(dir, "app-release.aab")}, aabPaths)
+}
+
+func Test_SelectAABs(t *testing.T) {
+	// Given
+	aabPaths := []string{
+		"/deploy/app-demo-debug.aab",
+		"/deploy/app-demo-release.aab",
+		"/deploy/app-demo-release-bitrise-signed.aab",
+		"/deploy/app-full-release.aab",
+		"/deploy/wear-release.aab",
+	}
+
+	// When
+	selected := SelectAABs(aabPaths, VariantFilter{ExcludeBuildTypes: []string{"Debug"}})
+
+	// Then
+	require.Equal(t, []VariantAAB{
+		{Variant: Variant{Module: "app", ProductFlavour: "demo", BuildType: "release"}, AABPath: "/deploy/app-demo-release.aab"},
+		{Variant: Variant{Module: "app", ProductFlavour: "full", BuildType: "release"}, AABPath: "/deploy/app-full-release.aab"},
+		{Variant: Variant{Module: "wear", BuildType: "release"}, AABPath: "/deploy/wear-release.aab"},
+	}, selected)
+}
+
+func Test_VariantFilter_Match(t *testing.T) {
+	variant := Variant{Module: "app", ProductFlavour: "minApi21-demo", BuildType: "release"}
+
+	require.True(t, VariantFilter{}.Match(variant))
+	require.True(t, VariantFilter{IncludeFlavours: []string{"demo"}, IncludeBuildTypes: []string{"release"}}.Match(variant))
+	require.True(t, VariantFilter{IncludeFlavours: []string{"minApi21-demo"}}.Match(variant))
+	require.False(t, VariantFilter{IncludeFlavours: []string{"full"}}.Match(variant))
+	require.False(t, VariantFilter{IncludeBuildTypes: []string{"debug"}}.Match(variant))
+	require.False(t, VariantFilter{ExcludeFlavours: []string{"MINAPI21"}}.Match(variant))
+}
+
+func Test_Variant_String(t *testing.T) {
+	require.Equal(t, "app:demoRelease", Variant{Module: "app", ProductFlavour: "demo", BuildType: "release"}.String())
+	require.Equal(t, "app:release", Variant{Module: "app", BuildType: "release"}.String())
+	require.Equal(t, "app:minApi21DemoRelease", Variant{Module: "app", ProductFlavour: "minApi21-demo", BuildType: "release"}.String())
+}
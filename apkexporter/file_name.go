@@ -176,7 +176,7 @@ func FindSameArtifact(pth string, pths []string) string {
 func mapBuildArtifacts(pths []string) ArtifactMap {
 	buildArtifacts := map[string]map[string]map[string]Artifact{}
 	for _, pth := range pths {
-		info := ParseArtifactPath(pth)
+		info := ResolveArtifactInfo(pth)
 
 		moduleArtifacts, ok := buildArtifacts[info.Module]
 		if !ok {
@@ -247,7 +247,7 @@ type SplitArtifactMeta Artifact
 // CreateSplitArtifactMeta ...
 func CreateSplitArtifactMeta(pth string, pths []string) (SplitArtifactMeta, error) {
 	artifactsMap := mapBuildArtifacts(pths)
-	info := ParseArtifactPath(pth)
+	info := ResolveArtifactInfo(pth)
 
 	moduleArtifacts, ok := artifactsMap[info.Module]
 	if !ok {
@@ -268,9 +268,10 @@ func CreateSplitArtifactMeta(pth string, pths []string) (SplitArtifactMeta, erro
 }
 
 // UniversalAPKBase returns the aab's universal apk pair's base name.
+// The variant is resolved by ResolveArtifactInfo.
 func UniversalAPKBase(basedOnAAB string) string {
 	// <module>-<product_flavor>?-universal-<build type>-<unsigned|bitrise-signed>?.apk
-	info := ParseArtifactPath(basedOnAAB)
+	info := ResolveArtifactInfo(basedOnAAB)
 
 	nameParts := []string{info.Module}
 	if len(info.ProductFlavour) > 0 {
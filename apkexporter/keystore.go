@@ -0,0 +1,90 @@
+package apkexporter
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/bundletool"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/keystore"
+)
+
+// KeystoreValidator represents a type that can check the signing configuration before running bundletool.
+type KeystoreValidator interface {
+	Validate(keystoreConfig bundletool.KeystoreConfig) error
+	SigningKeyAlias(keystoreConfig bundletool.KeystoreConfig) (string, error)
+	SigningCertificate(keystoreConfig bundletool.KeystoreConfig) (*x509.Certificate, error)
+}
+
+// nativeKeystoreValidator reads JKS and PKCS #12 keystores in Go,
+// to report a wrong password or alias without bundletool's Java stack trace.
+type nativeKeystoreValidator struct{}
+
+// Validate checks the store password, the alias and the key password.
+// Keystore formats and algorithms which can not be handled are left for bundletool to validate.
+func (nativeKeystoreValidator) Validate(keystoreConfig bundletool.KeystoreConfig) error {
+	ks, err := openKeystore(keystoreConfig)
+	if isUnsupportedKeystore(err) {
+		log.Warnf("Skipping keystore validation: %s", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid keystore: %w", err)
+	}
+
+	keyPassword, err := bundletool.PasswordSourceFromArg(keystoreConfig.SigningKeyPassword).Read(nil)
+	if err != nil {
+		return fmt.Errorf("private key password: %w", err)
+	}
+	err = ks.CheckKey(keystoreConfig.SigningKeyAlias, keyPassword)
+	if errors.Is(err, keystore.ErrUnsupportedAlgorithm) {
+		log.Warnf("Skipping signing key validation: %s", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid signing key: %w", err)
+	}
+	log.Printf("%s keystore validated", ks.Type)
+	return nil
+}
+
+// SigningKeyAlias returns the alias of the keystore's only private key.
+// bundletool requires the alias, so it has to be set explicitly if the keystore can not be read.
+func (nativeKeystoreValidator) SigningKeyAlias(keystoreConfig bundletool.KeystoreConfig) (string, error) {
+	ks, err := openKeystore(keystoreConfig)
+	if isUnsupportedKeystore(err) {
+		log.Warnf("Skipping keystore alias detection: %s", err)
+		return "", fmt.Errorf("keystore alias can not be detected: %w, set the Keystore alias input", err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid keystore: %w", err)
+	}
+	return ks.SigningKeyAlias()
+}
+
+// SigningCertificate returns the certificate of the signing key.
+// If the keystore can not be read, the error makes the caller fall back to running bundletool.
+func (nativeKeystoreValidator) SigningCertificate(keystoreConfig bundletool.KeystoreConfig) (*x509.Certificate, error) {
+	ks, err := openKeystore(keystoreConfig)
+	if isUnsupportedKeystore(err) {
+		log.Warnf("The signing certificate can not be read from the keystore: %s", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ks.Certificate(keystoreConfig.SigningKeyAlias)
+}
+
+// isUnsupportedKeystore tells if the keystore can not be read in Go, while bundletool may still sign with it.
+func isUnsupportedKeystore(err error) bool {
+	return errors.Is(err, keystore.ErrUnsupportedFormat) || errors.Is(err, keystore.ErrUnsupportedAlgorithm)
+}
+
+func openKeystore(keystoreConfig bundletool.KeystoreConfig) (*keystore.Keystore, error) {
+	storePassword, err := bundletool.PasswordSourceFromArg(keystoreConfig.KeystorePassword).Read(nil)
+	if err != nil {
+		return nil, fmt.Errorf("keystore password: %w", err)
+	}
+	return keystore.Open(keystoreConfig.Path, storePassword)
+}
@@ -0,0 +1,214 @@
+package apkexporter
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// outputMetadataFileName is the listing file the Android Gradle Plugin writes next to the APK outputs.
+	outputMetadataFileName = "output-metadata.json"
+	// bundleRedirectFileDir is where the Android Gradle Plugin writes the redirect files to the bundle listing files,
+	// as <variant>/redirect.txt or <variant>/<task>/redirect.txt.
+	bundleRedirectFileDir = "intermediates/bundle_ide_redirect_file"
+	redirectFileName      = "redirect.txt"
+	listingFileKey        = "listingFile="
+)
+
+// outputMetadata is the content of an output-metadata.json file, based on AGP's BuiltArtifactsImpl.
+type outputMetadata struct {
+	VariantName string                  `json:"variantName"`
+	Elements    []outputMetadataElement `json:"elements"`
+}
+
+type outputMetadataElement struct {
+	// Type is one of SINGLE, ONE_OF_MANY and UNIVERSAL.
+	Type        string                 `json:"type"`
+	Filters     []outputMetadataFilter `json:"filters"`
+	VersionName string                 `json:"versionName"`
+	OutputFile  string                 `json:"outputFile"`
+}
+
+type outputMetadataFilter struct {
+	FilterType string `json:"filterType"`
+	Value      string `json:"value"`
+}
+
+// ResolveArtifactInfo returns the artifact's info based on the Android Gradle Plugin's listing file, if the artifact is listed there,
+// see readOutputMetadata. The variant name is split into product flavour and build type (see flavourDimensions),
+// the module is the Gradle project directory containing the build/outputs directory.
+// Falls back to parsing the artifact's file name, see ParseArtifactPath.
+func ResolveArtifactInfo(pth string) ArtifactInfo {
+	info := ParseArtifactPath(pth)
+
+	metadata, element := readOutputMetadata(pth)
+	if element == nil || metadata.VariantName == "" {
+		return info
+	}
+
+	resolved := ArtifactInfo{
+		Module:      info.Module,
+		SigningInfo: info.SigningInfo,
+	}
+	if module := gradleModule(pth); module != "" {
+		resolved.Module = module
+	}
+	resolved.ProductFlavour, resolved.BuildType = splitVariantName(metadata.VariantName, info.BuildType)
+	resolved.ProductFlavour = flavourDimensions(resolved.ProductFlavour, info.ProductFlavour)
+
+	for _, filter := range element.Filters {
+		resolved.SplitInfo.SplitParams = append(resolved.SplitInfo.SplitParams, filter.Value)
+	}
+	if element.Type == "UNIVERSAL" {
+		resolved.SplitInfo.Universal = true
+		resolved.SplitInfo.SplitParams = append(resolved.SplitInfo.SplitParams, universalSplitParam)
+	}
+	return resolved
+}
+
+// readOutputMetadata returns the listing file listing the artifact, and the artifact's element (nil if not listed).
+// APKs are listed in the output-metadata.json next to them, bundles in the listing file referenced by
+// the redirect file under the module's build/intermediates directory.
+func readOutputMetadata(pth string) (outputMetadata, *outputMetadataElement) {
+	for _, listingFile := range listingFiles(pth) {
+		metadata, element, err := readListingFile(listingFile, pth)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Warnf("Failed to read %s, falling back to the artifact name: %s", listingFile, err)
+			}
+			continue
+		}
+		if element != nil {
+			return metadata, element
+		}
+	}
+	return outputMetadata{}, nil
+}
+
+// listingFiles returns the listing files which may list the artifact.
+func listingFiles(pth string) []string {
+	files := []string{filepath.Join(filepath.Dir(pth), outputMetadataFileName)}
+
+	buildDir := gradleBuildDir(pth)
+	if buildDir == "" {
+		return files
+	}
+	redirectDir := filepath.Join(buildDir, filepath.FromSlash(bundleRedirectFileDir))
+	variantRedirects, _ := filepath.Glob(filepath.Join(redirectDir, "*", redirectFileName))
+	taskRedirects, _ := filepath.Glob(filepath.Join(redirectDir, "*", "*", redirectFileName))
+	for _, redirect := range append(variantRedirects, taskRedirects...) {
+		listingFile, err := readRedirectFile(redirect)
+		if err != nil {
+			log.Warnf("Failed to read %s: %s", redirect, err)
+			continue
+		}
+		files = append(files, listingFile)
+	}
+	return files
+}
+
+// readRedirectFile returns the listing file path of a redirect file, which is relative to the redirect file's directory.
+func readRedirectFile(pth string) (string, error) {
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, listingFileKey) {
+			continue
+		}
+		listingFile := filepath.FromSlash(strings.TrimPrefix(line, listingFileKey))
+		if !filepath.IsAbs(listingFile) {
+			listingFile = filepath.Join(filepath.Dir(pth), listingFile)
+		}
+		return listingFile, nil
+	}
+	return "", errors.New("no " + strings.TrimSuffix(listingFileKey, "=") + " found")
+}
+
+// readListingFile reads an output-metadata.json listing file, and returns the artifact's element (nil if not listed).
+// The elements' output files are relative to the listing file's directory.
+func readListingFile(listingFile, pth string) (outputMetadata, *outputMetadataElement, error) {
+	content, err := os.ReadFile(listingFile)
+	if err != nil {
+		return outputMetadata{}, nil, err
+	}
+
+	var metadata outputMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return outputMetadata{}, nil, err
+	}
+	for i, element := range metadata.Elements {
+		outputFile := filepath.FromSlash(element.OutputFile)
+		if !filepath.IsAbs(outputFile) {
+			outputFile = filepath.Join(filepath.Dir(listingFile), outputFile)
+		}
+		if outputFile == filepath.Clean(pth) {
+			return metadata, &metadata.Elements[i], nil
+		}
+	}
+	return metadata, nil, nil
+}
+
+// gradleModule returns the name of the Gradle project directory of an artifact in its <module>/build/outputs directory.
+func gradleModule(pth string) string {
+	buildDir := gradleBuildDir(pth)
+	if buildDir == "" {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(buildDir))
+}
+
+// gradleBuildDir returns the <module>/build directory of an artifact in its <module>/build/outputs directory.
+func gradleBuildDir(pth string) string {
+	parts := strings.Split(filepath.ToSlash(filepath.Dir(pth)), "/")
+	for i := len(parts) - 2; i > 0; i-- {
+		if parts[i] == "build" && parts[i+1] == "outputs" && parts[i-1] != "" {
+			return filepath.FromSlash(strings.Join(parts[:i+1], "/"))
+		}
+	}
+	return ""
+}
+
+// splitVariantName splits a variant name (for example minApi21DemoRelease) into product flavour and build type.
+// The build type parsed from the artifact name is used if the variant name ends with it,
+// otherwise the build type is the variant name's last camel case word.
+func splitVariantName(variantName, buildTypeHint string) (string, string) {
+	if buildTypeHint != "" && len(variantName) >= len(buildTypeHint) {
+		prefix, suffix := variantName[:len(variantName)-len(buildTypeHint)], variantName[len(variantName)-len(buildTypeHint):]
+		if strings.EqualFold(suffix, buildTypeHint) && (prefix == "" || unicode.IsUpper(rune(suffix[0]))) {
+			return prefix, firstLetterLower(suffix)
+		}
+	}
+
+	last := strings.LastIndexFunc(variantName, unicode.IsUpper)
+	if last <= 0 {
+		return "", variantName
+	}
+	return variantName[:last], firstLetterLower(variantName[last:])
+}
+
+// flavourDimensions returns the variant name's flavour (for example minApi21Demo) in the dash separated form
+// of the artifact name (minApi21-demo), if the artifact name has the same flavour. The variant name does not tell
+// where the dimensions of a multi dimension flavour end, so otherwise it is returned as a single dimension.
+func flavourDimensions(variantFlavour, nameFlavour string) string {
+	if nameFlavour != "" && variantFlavourName(nameFlavour) == variantFlavour {
+		return nameFlavour
+	}
+	return variantFlavour
+}
+
+func firstLetterLower(str string) string {
+	for i, v := range str {
+		return string(unicode.ToLower(v)) + str[i+1:]
+	}
+	return ""
+}
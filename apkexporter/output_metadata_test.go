@@ -0,0 +1,135 @@
+package apkexporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ResolveArtifactInfo_BundleListingFile(t *testing.T) {
+	// Given the layout written by the Android Gradle Plugin 8 bundleDemoRelease task
+	buildDir := filepath.Join(t.TempDir(), "mobile", "build")
+	aabPath := filepath.Join(buildDir, "outputs", "bundle", "demoRelease", "myapp-demo-1.4.2-release.aab")
+	givenFile(t, aabPath, "aab")
+	givenFile(t, filepath.Join(buildDir, "intermediates", "bundle_ide_redirect_file", "demoRelease", "createDemoReleaseBundleListingFileRedirect", "redirect.txt"), `#- File Locator -
+listingFile=../../../bundle_ide_model/demoRelease/produceDemoReleaseBundleIdeListingFile/output-metadata.json
+`)
+	givenFile(t, filepath.Join(buildDir, "intermediates", "bundle_ide_model", "demoRelease", "produceDemoReleaseBundleIdeListingFile", outputMetadataFileName), `{
+  "version": 3,
+  "artifactType": {
+    "type": "BUNDLE",
+    "kind": "File"
+  },
+  "applicationId": "io.bitrise.sample",
+  "variantName": "demoRelease",
+  "elements": [
+    {
+      "type": "SINGLE",
+      "filters": [],
+      "attributes": [],
+      "versionCode": 42,
+      "versionName": "1.4.2",
+      "outputFile": "../../../../outputs/bundle/demoRelease/myapp-demo-1.4.2-release.aab"
+    }
+  ],
+  "elementType": "File"
+}`)
+
+	// When
+	info := ResolveArtifactInfo(aabPath)
+
+	// Then
+	require.Equal(t, ArtifactInfo{Module: "mobile", ProductFlavour: "demo", BuildType: "release"}, info)
+	require.Equal(t, "mobile-demo-universal-release.apk", UniversalAPKBase(aabPath))
+}
+
+func Test_ResolveArtifactInfo_VariantRedirectFile(t *testing.T) {
+	// Given the layout written by the Android Gradle Plugin 7 bundleStaging task
+	buildDir := filepath.Join(t.TempDir(), "app", "build")
+	aabPath := filepath.Join(buildDir, "outputs", "bundle", "staging", "sample.aab")
+	givenFile(t, filepath.Join(buildDir, "intermediates", "bundle_ide_redirect_file", "staging", "redirect.txt"), `#- File Locator -
+listingFile=../../bundle_ide_model/staging/output-metadata.json
+`)
+	givenFile(t, filepath.Join(buildDir, "intermediates", "bundle_ide_model", "staging", outputMetadataFileName),
+		`{"variantName": "staging", "elements": [{"type": "SINGLE", "outputFile": "../../../outputs/bundle/staging/sample.aab"}]}`)
+
+	// When
+	info := ResolveArtifactInfo(aabPath)
+
+	// Then
+	require.Equal(t, ArtifactInfo{Module: "app", BuildType: "staging"}, info)
+}
+
+func Test_ResolveArtifactInfo_NotListed(t *testing.T) {
+	// Given
+	outputDir := t.TempDir()
+	givenOutputMetadata(t, outputDir, `{"variantName": "demoRelease", "elements": [{"type": "SINGLE", "outputFile": "other.aab"}]}`)
+
+	// When
+	info := ResolveArtifactInfo(filepath.Join(outputDir, "app-full-debug.aab"))
+
+	// Then
+	require.Equal(t, ArtifactInfo{Module: "app", ProductFlavour: "full", BuildType: "debug"}, info)
+}
+
+func Test_ResolveArtifactInfo_UniversalAPK(t *testing.T) {
+	// Given
+	outputDir := t.TempDir()
+	givenOutputMetadata(t, outputDir, `{"variantName": "minApi21DemoStaging", "elements": [{"type": "UNIVERSAL", "filters": [], "outputFile": "custom.apk"}]}`)
+
+	// When
+	info := ResolveArtifactInfo(filepath.Join(outputDir, "custom.apk"))
+
+	// Then
+	require.Equal(t, ArtifactInfo{
+		ProductFlavour: "minApi21Demo",
+		BuildType:      "staging",
+		SplitInfo:      ArtifactSplitInfo{SplitParams: []string{universalSplitParam}, Universal: true},
+	}, info)
+}
+
+func Test_ResolveArtifactInfo_MultiDimensionFlavour(t *testing.T) {
+	// Given
+	outputDir := filepath.Join(t.TempDir(), "app", "build", "outputs", "apk", "minApi21Demo", "release")
+	apkPath := filepath.Join(outputDir, "app-minApi21-demo-universal-release.apk")
+	givenOutputMetadata(t, outputDir, `{"variantName": "minApi21DemoRelease", "elements": [{"type": "UNIVERSAL", "filters": [], "outputFile": "app-minApi21-demo-universal-release.apk"}]}`)
+
+	// When
+	info := ResolveArtifactInfo(apkPath)
+
+	// Then
+	require.Equal(t, ArtifactInfo{
+		Module:         "app",
+		ProductFlavour: "minApi21-demo",
+		BuildType:      "release",
+		SplitInfo:      ArtifactSplitInfo{SplitParams: []string{universalSplitParam}, Universal: true},
+	}, info)
+	variant := Variant{Module: info.Module, ProductFlavour: info.ProductFlavour, BuildType: info.BuildType}
+	require.True(t, VariantFilter{IncludeFlavours: []string{"demo"}}.Match(variant))
+	require.Equal(t, "app:minApi21DemoRelease", variant.String())
+}
+
+func Test_splitVariantName(t *testing.T) {
+	assertSplit := func(variantName, buildTypeHint, expectedFlavour, expectedBuildType string) {
+		flavour, buildType := splitVariantName(variantName, buildTypeHint)
+		require.Equal(t, expectedFlavour, flavour, variantName)
+		require.Equal(t, expectedBuildType, buildType, variantName)
+	}
+
+	assertSplit("release", "release", "", "release")
+	assertSplit("demoRelease", "release", "demo", "release")
+	assertSplit("demoQaRelease", "qaRelease", "demo", "qaRelease")
+	assertSplit("demoStaging", "", "demo", "staging")
+	assertSplit("debug", "", "", "debug")
+}
+
+func givenOutputMetadata(t *testing.T, dir, content string) {
+	givenFile(t, filepath.Join(dir, outputMetadataFileName), content)
+}
+
+func givenFile(t *testing.T, pth, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755))
+	require.NoError(t, os.WriteFile(pth, []byte(content), 0600))
+}
@@ -0,0 +1,90 @@
+package apkexporter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/apksignature"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/bundletool"
+)
+
+// variantUniversalAPK returns the universal apk of the bundle's variant in destDir, if any.
+func variantUniversalAPK(aabPath, destDir string) string {
+	apkPaths, err := filepath.Glob(filepath.Join(destDir, "*"+apkExtension))
+	if err != nil || len(apkPaths) == 0 {
+		return ""
+	}
+	meta, err := CreateSplitArtifactMeta(aabPath, append(apkPaths, aabPath))
+	if err != nil {
+		return ""
+	}
+	return meta.UniversalApk
+}
+
+// isReusableAPK tells if the apk has the bundle's digest embedded, and it is signed with the certificate
+// the current export would sign it with: the configured signing key's, or the Android debug certificate.
+func (exporter Exporter) isReusableAPK(apkPath string, aabDigest []byte, keystoreConfig *bundletool.KeystoreConfig) bool {
+	digest, err := apksignature.ReadBundleDigest(apkPath)
+	if err != nil {
+		log.Printf("Existing universal APK (%s) can not be reused: %s", apkPath, err)
+		return false
+	}
+	if !bytes.Equal(digest, aabDigest) {
+		log.Printf("Existing universal APK (%s) was exported from a different bundle", apkPath)
+		return false
+	}
+
+	result, err := apksignature.Verify(apkPath)
+	if err != nil {
+		log.Printf("Existing universal APK (%s) can not be reused: %s", apkPath, err)
+		return false
+	}
+
+	if err := exporter.checkReusedSigner(result, keystoreConfig); err != nil {
+		log.Printf("Existing universal APK (%s) can not be reused: %s", apkPath, err)
+		return false
+	}
+	return true
+}
+
+// checkReusedSigner checks that the existing APK is signed with the configured signing key's certificate,
+// or with the Android debug certificate if no keystore is configured.
+func (exporter Exporter) checkReusedSigner(result apksignature.Result, keystoreConfig *bundletool.KeystoreConfig) error {
+	if keystoreConfig == nil {
+		if !result.IsDebugSigned() {
+			return errors.New("it is not signed with the debug keystore")
+		}
+		return nil
+	}
+
+	if exporter.keystoreValidator == nil {
+		return errors.New("the signing certificate can not be read")
+	}
+	certificate, err := exporter.keystoreValidator.SigningCertificate(*keystoreConfig)
+	if err != nil {
+		return fmt.Errorf("failed to read the signing certificate: %w", err)
+	}
+	return result.CheckSigners([]string{apksignature.Fingerprint(certificate)})
+}
+
+func fileSHA256(pth string) ([]byte, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
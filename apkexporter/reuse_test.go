@@ -0,0 +1,111 @@
+package apkexporter
+
+import (
+	"archive/zip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/apksignature"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_exportUniversalAPK_ExistingAPKWithoutDigest(t *testing.T) {
+	// Given
+	destDir := t.TempDir()
+	aabPath := filepath.Join(destDir, "app-demo-release.aab")
+	require.NoError(t, os.WriteFile(aabPath, []byte("bundle"), 0600))
+	givenEmptyZip(t, filepath.Join(destDir, "app-demo-universal-release.apk"))
+	mockAPKBuilder := givenMockedAPKBuilder(givenFailingCommand())
+	exporter := givenExporter(mockAPKBuilder, givenMockFileDownloader()).WithReuseExisting()
+
+	// When
+	apkPath, reused, err := exporter.exportUniversalAPK(aabPath, destDir, "app-demo-universal-release.apk", nil)
+
+	// Then
+	require.Error(t, err)
+	require.Empty(t, apkPath)
+	require.False(t, reused)
+	mockAPKBuilder.AssertCalled(t, "BuildAPKs", aabPath, mock.Anything, mock.Anything)
+}
+
+func Test_checkReusedSigner(t *testing.T) {
+	// Given
+	release := givenCertificate(t, "Release")
+	other := givenCertificate(t, "Other")
+	debug := givenCertificate(t, "Android Debug")
+	keystoreConfig := givenKeystoreConfig("/keystore.jks")
+	mockKeystoreValidator := new(MockKeystoreValidator)
+	mockKeystoreValidator.On("SigningCertificate", *keystoreConfig).Return(release, nil)
+	exporter := givenExporter(givenMockedAPKBuilder(givenSuccessfulCommand()), givenMockFileDownloader())
+	exporter.keystoreValidator = mockKeystoreValidator
+
+	// When
+	releaseErr := exporter.checkReusedSigner(givenSignatureResult(release), keystoreConfig)
+	otherErr := exporter.checkReusedSigner(givenSignatureResult(other), keystoreConfig)
+	debugErr := exporter.checkReusedSigner(givenSignatureResult(debug), nil)
+	debugReleaseErr := exporter.checkReusedSigner(givenSignatureResult(release), nil)
+
+	// Then
+	require.NoError(t, releaseErr)
+	require.EqualError(t, otherErr, fmt.Sprintf("the APK is signed with an unexpected certificate: %s (CN=Other)", apksignature.Fingerprint(other)))
+	require.NoError(t, debugErr)
+	require.EqualError(t, debugReleaseErr, "it is not signed with the debug keystore")
+}
+
+func Test_fileSHA256(t *testing.T) {
+	// Given
+	pth := filepath.Join(t.TempDir(), "app.aab")
+	require.NoError(t, os.WriteFile(pth, []byte("bundle"), 0600))
+
+	// When
+	digest, err := fileSHA256(pth)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "1e6ed65d77d6364eeaed5a745ba5c4985ae2b700dd85d7cf7f027bdf294a33fc", hex.EncodeToString(digest))
+}
+
+func givenEmptyZip(t *testing.T, pth string) {
+	f, err := os.Create(pth)
+	require.NoError(t, err)
+	require.NoError(t, zip.NewWriter(f).Close())
+	require.NoError(t, f.Close())
+}
+
+func givenCertificate(t *testing.T, commonName string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return certificate
+}
+
+func givenSignatureResult(certificate *x509.Certificate) apksignature.Result {
+	return apksignature.Result{
+		Schemes: []apksignature.Scheme{apksignature.SchemeV2},
+		Signers: []apksignature.Signer{{
+			Subject:           certificate.Subject.String(),
+			SHA256Fingerprint: apksignature.Fingerprint(certificate),
+			Certificate:       certificate,
+		}},
+	}
+}
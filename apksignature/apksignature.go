@@ -0,0 +1,142 @@
+// Package apksignature verifies the signatures of an APK, based on: https://source.android.com/docs/security/features/apksigning
+package apksignature
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// debugCertificateCommonName is the common name of the debug certificate generated by the Android SDK.
+const debugCertificateCommonName = "Android Debug"
+
+// Scheme is an APK signature scheme.
+type Scheme string
+
+// Supported signature schemes.
+const (
+	SchemeV1 Scheme = "v1"
+	SchemeV2 Scheme = "v2"
+	SchemeV3 Scheme = "v3"
+)
+
+// Signer describes a signer certificate of the APK.
+type Signer struct {
+	Subject string
+	Issuer  string
+	// SHA256Fingerprint is the certificate's SHA-256 digest, as uppercase hex bytes separated by colons.
+	SHA256Fingerprint string
+	NotBefore         time.Time
+	NotAfter          time.Time
+	Certificate       *x509.Certificate
+}
+
+// Result is the outcome of a successful verification.
+type Result struct {
+	// Schemes lists the verified signature schemes.
+	Schemes []Scheme
+	// Signers lists the distinct signer certificates of all the verified schemes.
+	Signers []Signer
+}
+
+// IsDebugSigned returns true if any of the signers is the Android SDK's debug certificate.
+func (result Result) IsDebugSigned() bool {
+	for _, signer := range result.Signers {
+		if signer.Certificate.Subject.CommonName == debugCertificateCommonName {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify verifies the v1, v2 and v3 signatures of an APK.
+// It returns an error if the APK is not signed or any of its signatures is invalid.
+func Verify(apkPath string) (Result, error) {
+	f, size, err := openAPK(apkPath)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close APK (%s): %s", apkPath, err)
+		}
+	}()
+
+	var result Result
+	addSigners := func(scheme Scheme, certificates []*x509.Certificate) {
+		result.Schemes = append(result.Schemes, scheme)
+		for _, certificate := range certificates {
+			result.addSigner(certificate)
+		}
+	}
+
+	block, err := readSigningBlock(f, size)
+	if err != nil && !errors.Is(err, ErrNoSigningBlock) {
+		return Result{}, err
+	}
+	for _, v2Scheme := range []struct {
+		scheme Scheme
+		id     uint32
+	}{
+		{SchemeV3, v3BlockID},
+		{SchemeV2, v2BlockID},
+	} {
+		value, ok := block.pairs[v2Scheme.id]
+		if !ok {
+			continue
+		}
+		certificates, err := verifyV2Block(f, block.sections, value, v2Scheme.scheme == SchemeV3)
+		if err != nil {
+			return Result{}, fmt.Errorf("invalid %s signature: %w", v2Scheme.scheme, err)
+		}
+		addSigners(v2Scheme.scheme, certificates)
+	}
+
+	certificates, err := verifyV1(f, size)
+	switch {
+	case errors.Is(err, errNotSigned):
+		// APKs targeting Android 7.0+ may only be signed with the v2+ schemes
+	case err != nil:
+		return Result{}, fmt.Errorf("invalid %s signature: %w", SchemeV1, err)
+	default:
+		addSigners(SchemeV1, certificates)
+	}
+
+	if len(result.Schemes) == 0 {
+		return Result{}, errors.New("the APK is not signed")
+	}
+	return result, nil
+}
+
+func (result *Result) addSigner(certificate *x509.Certificate) {
+	fingerprint := Fingerprint(certificate)
+	for _, signer := range result.Signers {
+		if signer.SHA256Fingerprint == fingerprint {
+			return
+		}
+	}
+
+	result.Signers = append(result.Signers, Signer{
+		Subject:           certificate.Subject.String(),
+		Issuer:            certificate.Issuer.String(),
+		SHA256Fingerprint: fingerprint,
+		NotBefore:         certificate.NotBefore,
+		NotAfter:          certificate.NotAfter,
+		Certificate:       certificate,
+	})
+}
+
+// Fingerprint returns the certificate's SHA-256 digest, as uppercase hex bytes separated by colons.
+func Fingerprint(certificate *x509.Certificate) string {
+	digest := sha256.Sum256(certificate.Raw)
+	parts := make([]string, len(digest))
+	for i, b := range digest {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
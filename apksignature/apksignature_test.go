@@ -0,0 +1,356 @@
+package apksignature
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify_V2AndV3(t *testing.T) {
+	// Given
+	key, certificate := givenSigner(t, "Release")
+	apk := givenV2SignedAPK(t, givenZip(t, map[string]string{"classes.dex": "dex"}), key, certificate)
+
+	// When
+	result, err := Verify(apk)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []Scheme{SchemeV3, SchemeV2}, result.Schemes)
+	require.Equal(t, 1, len(result.Signers))
+	require.Equal(t, "CN=Release", result.Signers[0].Subject)
+	require.Equal(t, Fingerprint(certificate), result.Signers[0].SHA256Fingerprint)
+	require.Equal(t, certificate.NotAfter, result.Signers[0].NotAfter)
+	require.False(t, result.IsDebugSigned())
+}
+
+func TestVerify_V2Modified(t *testing.T) {
+	// Given
+	key, certificate := givenSigner(t, "Release")
+	apk := givenV2SignedAPK(t, givenZip(t, map[string]string{"classes.dex": "dex"}), key, certificate)
+	content, err := os.ReadFile(apk)
+	require.NoError(t, err)
+	content[bytes.Index(content, []byte("classes.dex"))] = 'C'
+	require.NoError(t, os.WriteFile(apk, content, 0600))
+
+	// When
+	_, err = Verify(apk)
+
+	// Then
+	require.EqualError(t, err, "invalid v3 signature: signer #1: APK content digest mismatch, the APK was modified after signing")
+}
+
+func TestVerify_V1(t *testing.T) {
+	// Given
+	key, certificate := givenSigner(t, debugCertificateCommonName)
+	entries := map[string]string{"classes.dex": "dex", "res/layout/main.xml": "xml"}
+	manifest := givenManifest(entries)
+	apk := givenV1SignedAPK(t, key, certificate, entries, manifest, givenSignatureFile(manifest, true))
+
+	// When
+	result, err := Verify(apk)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []Scheme{SchemeV1}, result.Schemes)
+	require.Equal(t, "CN=Android Debug", result.Signers[0].Subject)
+	require.True(t, result.IsDebugSigned())
+}
+
+func TestVerify_V1ManifestModified(t *testing.T) {
+	// Given
+	key, certificate := givenSigner(t, "Release")
+	entries := map[string]string{"classes.dex": "modified"}
+	sf := givenSignatureFile(givenManifest(map[string]string{"classes.dex": "dex"}), true)
+	apk := givenV1SignedAPK(t, key, certificate, entries, givenManifest(entries), sf)
+
+	// When
+	_, err := Verify(apk)
+
+	// Then
+	require.EqualError(t, err, "invalid v1 signature: META-INF/CERT.RSA: SHA-256-Digest-Manifest mismatch, the manifest was modified after signing")
+}
+
+func TestVerify_V1ManifestSectionModified(t *testing.T) {
+	// Given
+	key, certificate := givenSigner(t, "Release")
+	entries := map[string]string{"classes.dex": "modified"}
+	sf := givenSignatureFile(givenManifest(map[string]string{"classes.dex": "dex"}), false)
+	apk := givenV1SignedAPK(t, key, certificate, entries, givenManifest(entries), sf)
+
+	// When
+	_, err := Verify(apk)
+
+	// Then
+	require.EqualError(t, err, "invalid v1 signature: META-INF/CERT.RSA: classes.dex: SHA-256-Digest mismatch, the manifest section was modified after signing")
+}
+
+func TestVerify_V1EntryModified(t *testing.T) {
+	// Given
+	key, certificate := givenSigner(t, "Release")
+	manifest := givenManifest(map[string]string{"classes.dex": "dex"})
+	entries := map[string]string{"classes.dex": "modified"}
+	apk := givenV1SignedAPK(t, key, certificate, entries, manifest, givenSignatureFile(manifest, false))
+
+	// When
+	_, err := Verify(apk)
+
+	// Then
+	require.EqualError(t, err, "invalid v1 signature: classes.dex: SHA-256-Digest mismatch, the entry was modified after signing")
+}
+
+func TestVerify_V1EntryAdded(t *testing.T) {
+	// Given
+	key, certificate := givenSigner(t, "Release")
+	manifest := givenManifest(map[string]string{"classes.dex": "dex"})
+	entries := map[string]string{"classes.dex": "dex", "classes2.dex": "added"}
+	apk := givenV1SignedAPK(t, key, certificate, entries, manifest, givenSignatureFile(manifest, true))
+
+	// When
+	_, err := Verify(apk)
+
+	// Then
+	require.EqualError(t, err, "invalid v1 signature: classes2.dex is not listed in the manifest, the entry was added after signing")
+}
+
+func Test_verifyPKCS7_IssuerMismatch(t *testing.T) {
+	// Given
+	key, certificate := givenSigner(t, "Release")
+	_, other := givenSigner(t, "Other")
+	block := givenSignatureBlock(t, key, certificate, other.RawIssuer, "sf")
+
+	// When
+	_, err := verifyPKCS7(block, []byte("sf"))
+
+	// Then
+	require.Equal(t, certificate.SerialNumber, other.SerialNumber)
+	require.EqualError(t, err, "signer certificate not found")
+}
+
+func TestVerify_Unsigned(t *testing.T) {
+	// Given
+	apk := givenZip(t, map[string]string{"classes.dex": "dex"})
+
+	// When
+	_, err := Verify(apk)
+
+	// Then
+	require.EqualError(t, err, "the APK is not signed")
+}
+
+func givenSigner(t *testing.T, commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return key, certificate
+}
+
+func givenZip(t *testing.T, files map[string]string) string {
+	pth := filepath.Join(t.TempDir(), "app.apk")
+	f, err := os.Create(pth)
+	require.NoError(t, err)
+
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		entry, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		require.NoError(t, err)
+		_, err = entry.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+	return pth
+}
+
+// givenV2SignedAPK inserts an APK Signing Block with a v2 and a v3 signature made with the RSA PKCS #1 SHA-256 algorithm.
+func givenV2SignedAPK(t *testing.T, apk string, key *rsa.PrivateKey, certificate *x509.Certificate) string {
+	content, err := os.ReadFile(apk)
+	require.NoError(t, err)
+	sections, err := readZipSections(bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+	digest, err := contentDigest(bytes.NewReader(content), sections, crypto.SHA256)
+	require.NoError(t, err)
+
+	signer := func(v3 bool) []byte {
+		signedData := concat(
+			lp(lp(concat(u32(sigRSAPKCS1SHA256), lp(digest)))),
+			lp(lp(certificate.Raw)),
+			lp(nil),
+		)
+		hashed := sha256.Sum256(signedData)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+		require.NoError(t, err)
+
+		signer := lp(signedData)
+		if v3 {
+			signer = concat(signer, u32(24), u32(0x7fffffff))
+		}
+		return lp(lp(concat(
+			signer,
+			lp(lp(concat(u32(sigRSAPKCS1SHA256), lp(signature)))),
+			lp(certificate.RawSubjectPublicKeyInfo),
+		)))
+	}
+
+	var pairs []byte
+	for id, value := range map[uint32][]byte{v2BlockID: signer(false), v3BlockID: signer(true)} {
+		pairs = concat(pairs, u64(uint64(4+len(value))), u32(id), value)
+	}
+	blockSize := uint64(len(pairs) + signingBlockFooterSize)
+	block := concat(u64(blockSize), pairs, u64(blockSize), []byte(signingBlockMagic))
+
+	eocd := bytes.Clone(sections.eocd)
+	binary.LittleEndian.PutUint32(eocd[eocdCDOffsetOffset:], uint32(sections.cdOffset)+uint32(len(block)))
+	signed := concat(content[:sections.cdOffset], block, content[sections.cdOffset:sections.eocdOffset], eocd)
+	require.NoError(t, os.WriteFile(apk, signed, 0600))
+	return apk
+}
+
+// givenManifest creates a JAR manifest with the SHA-256 digest of the entries.
+func givenManifest(entries map[string]string) string {
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	manifest := "Manifest-Version: 1.0\r\n\r\n"
+	for _, name := range names {
+		digest := sha256.Sum256([]byte(entries[name]))
+		manifest += "Name: " + name + "\r\nSHA-256-Digest: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n\r\n"
+	}
+	return manifest
+}
+
+// givenSignatureFile creates a .SF file with the SHA-256 digest of the manifest sections,
+// and optionally of the whole manifest.
+func givenSignatureFile(manifest string, withManifestDigest bool) string {
+	sf := "Signature-Version: 1.0\r\n"
+	if withManifestDigest {
+		digest := sha256.Sum256([]byte(manifest))
+		sf += "SHA-256-Digest-Manifest: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n"
+	}
+	sf += "\r\n"
+
+	for _, section := range strings.SplitAfter(manifest, "\r\n\r\n")[1:] {
+		if section == "" {
+			continue
+		}
+		name := strings.TrimPrefix(section[:strings.Index(section, "\r\n")], "Name: ")
+		digest := sha256.Sum256([]byte(section))
+		sf += "Name: " + name + "\r\nSHA-256-Digest: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n\r\n"
+	}
+	return sf
+}
+
+// givenV1SignedAPK creates a JAR signed APK from the entries, the manifest and the signature file.
+func givenV1SignedAPK(t *testing.T, key *rsa.PrivateKey, certificate *x509.Certificate, entries map[string]string, manifest, sf string) string {
+	files := map[string]string{
+		manifestName:        manifest,
+		"META-INF/CERT.SF":  sf,
+		"META-INF/CERT.RSA": string(givenSignatureBlock(t, key, certificate, certificate.RawIssuer, sf)),
+	}
+	for name, content := range entries {
+		files[name] = content
+	}
+	return givenZip(t, files)
+}
+
+// givenSignatureBlock creates a PKCS #7 signature block over the .SF file, identifying the signer by the issuer
+// and the certificate's serial number.
+func givenSignatureBlock(t *testing.T, key *rsa.PrivateKey, certificate *x509.Certificate, issuer []byte, sf string) []byte {
+	hashed := sha256.Sum256([]byte(sf))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	require.NoError(t, err)
+
+	sha256Algorithm := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+		ContentInfo:      contentInfo{ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificate.Raw},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: issuer},
+				SerialNumber: certificate.SerialNumber,
+			},
+			DigestAlgorithm:           sha256Algorithm,
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+			EncryptedDigest:           signature,
+		}},
+	})
+	require.NoError(t, err)
+	block, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	require.NoError(t, err)
+	return block
+}
+
+func Test_verifySignature_ECDSA(t *testing.T) {
+	// Given
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	hashed := sha256.Sum256([]byte("signed"))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hashed[:])
+	require.NoError(t, err)
+	algorithm := signatureAlgorithms[sigECDSASHA256]
+
+	// When
+	validErr := verifySignature(&key.PublicKey, algorithm, []byte("signed"), signature)
+	invalidErr := verifySignature(&key.PublicKey, algorithm, []byte("modified"), signature)
+
+	// Then
+	require.NoError(t, validErr)
+	require.EqualError(t, invalidErr, "invalid ECDSA signature")
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func lp(value []byte) []byte {
+	return concat(u32(uint32(len(value))), value)
+}
+
+func u32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+func u64(v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, v)
+}
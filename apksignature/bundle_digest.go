@@ -0,0 +1,151 @@
+package apksignature
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// bundleDigestBlockID is the APK Signing Block pair holding the SHA-256 digest of the bundle the APK was exported from.
+	// The block is not covered by the v2+ signatures, so the pair can be added after signing.
+	bundleDigestBlockID uint32 = 0x41414244 // "AABD"
+	// verityPaddingBlockID pads the APK Signing Block to a multiple of 4096 bytes, added by apksigner and bundletool.
+	verityPaddingBlockID uint32 = 0x42726577
+
+	verityPaddingAlignment = 4096
+)
+
+// ErrNoBundleDigest is returned if the APK has no embedded bundle digest.
+var ErrNoBundleDigest = errors.New("no bundle digest embedded in the APK")
+
+// ReadBundleDigest returns the bundle digest embedded by EmbedBundleDigest.
+func ReadBundleDigest(apkPath string) ([]byte, error) {
+	f, size, err := openAPK(apkPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	block, err := readSigningBlock(f, size)
+	if errors.Is(err, ErrNoSigningBlock) {
+		return nil, ErrNoBundleDigest
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	digest, ok := block.pairs[bundleDigestBlockID]
+	if !ok {
+		return nil, ErrNoBundleDigest
+	}
+	if len(digest) != sha256.Size {
+		return nil, fmt.Errorf("invalid bundle digest size: %d", len(digest))
+	}
+	return digest, nil
+}
+
+// EmbedBundleDigest stores the SHA-256 digest of the bundle in the APK's Signing Block, replacing the previous one.
+// The signatures stay valid, the APK has to be signed with the v2+ scheme. The APK is replaced atomically.
+func EmbedBundleDigest(apkPath string, digest []byte) error {
+	if len(digest) != sha256.Size {
+		return fmt.Errorf("invalid bundle digest size: %d", len(digest))
+	}
+
+	f, size, err := openAPK(apkPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	block, err := readSigningBlock(f, size)
+	if err != nil {
+		return err
+	}
+	sections := block.sections
+	blockData := make([]byte, sections.cdOffset-sections.signingBlockOffset)
+	if _, err := f.ReadAt(blockData, sections.signingBlockOffset); err != nil {
+		return err
+	}
+	pairs, err := parseSigningBlockPairList(blockData[8 : len(blockData)-signingBlockFooterSize])
+	if err != nil {
+		return err
+	}
+
+	newBlock := encodeSigningBlock(withBundleDigest(pairs, digest))
+	eocd := sections.eocd
+	binary.LittleEndian.PutUint32(eocd[eocdCDOffsetOffset:], uint32(sections.signingBlockOffset)+uint32(len(newBlock)))
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(apkPath), ".bundle-digest-*.apk")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if err := writeAPKSections(tmp, f, sections, newBlock, eocd); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), apkPath)
+}
+
+// withBundleDigest replaces the bundle digest pair, and resizes the verity padding pair (if any) to keep the block aligned.
+func withBundleDigest(pairs []signingBlockPair, digest []byte) []signingBlockPair {
+	var result []signingBlockPair
+	hasPadding := false
+	for _, pair := range pairs {
+		switch pair.id {
+		case bundleDigestBlockID:
+		case verityPaddingBlockID:
+			hasPadding = true
+		default:
+			result = append(result, pair)
+		}
+	}
+	result = append(result, signingBlockPair{id: bundleDigestBlockID, value: digest})
+
+	if hasPadding {
+		size := 8 + signingBlockFooterSize + 12
+		for _, pair := range result {
+			size += 12 + len(pair.value)
+		}
+		padding := (verityPaddingAlignment - size%verityPaddingAlignment) % verityPaddingAlignment
+		result = append(result, signingBlockPair{id: verityPaddingBlockID, value: make([]byte, padding)})
+	}
+	return result
+}
+
+// writeAPKSections writes the APK's entries, the new APK Signing Block, the central directory and the EOCD record.
+func writeAPKSections(w io.Writer, r io.ReaderAt, sections zipSections, signingBlock, eocd []byte) error {
+	if _, err := io.Copy(w, io.NewSectionReader(r, 0, sections.signingBlockOffset)); err != nil {
+		return err
+	}
+	if _, err := w.Write(signingBlock); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(r, sections.cdOffset, sections.cdSize)); err != nil {
+		return err
+	}
+	_, err := w.Write(eocd)
+	return err
+}
@@ -0,0 +1,58 @@
+package apksignature
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbedBundleDigest(t *testing.T) {
+	// Given
+	key, certificate := givenSigner(t, "Release")
+	apk := givenV2SignedAPK(t, givenZip(t, map[string]string{"classes.dex": "dex"}), key, certificate)
+	previous := sha256.Sum256([]byte("previous bundle"))
+	digest := sha256.Sum256([]byte("bundle"))
+	require.NoError(t, EmbedBundleDigest(apk, previous[:]))
+
+	// When
+	err := EmbedBundleDigest(apk, digest[:])
+
+	// Then
+	require.NoError(t, err)
+	embedded, err := ReadBundleDigest(apk)
+	require.NoError(t, err)
+	require.Equal(t, digest[:], embedded)
+	result, err := Verify(apk)
+	require.NoError(t, err)
+	require.Equal(t, []Scheme{SchemeV3, SchemeV2}, result.Schemes)
+}
+
+func TestEmbedBundleDigest_Unsigned(t *testing.T) {
+	// Given
+	apk := givenZip(t, map[string]string{"classes.dex": "dex"})
+	digest := sha256.Sum256([]byte("bundle"))
+
+	// When
+	err := EmbedBundleDigest(apk, digest[:])
+
+	// Then
+	require.ErrorIs(t, err, ErrNoSigningBlock)
+	_, err = ReadBundleDigest(apk)
+	require.ErrorIs(t, err, ErrNoBundleDigest)
+}
+
+func Test_withBundleDigest_VerityPadding(t *testing.T) {
+	// Given
+	pairs := []signingBlockPair{
+		{id: v2BlockID, value: make([]byte, 1000)},
+		{id: verityPaddingBlockID, value: make([]byte, 3032)},
+	}
+	digest := sha256.Sum256([]byte("bundle"))
+
+	// When
+	block := encodeSigningBlock(withBundleDigest(pairs, digest[:]))
+
+	// Then
+	require.Equal(t, 0, len(block)%verityPaddingAlignment)
+}
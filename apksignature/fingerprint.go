@@ -0,0 +1,51 @@
+package apksignature
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ParseFingerprints parses a list of SHA-256 fingerprints separated by newlines, commas or `|`.
+// The fingerprints may be given with or without colons, in any case (for example as printed by keytool or apksigner).
+func ParseFingerprints(s string) ([]string, error) {
+	var fingerprints []string
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ',' || r == '|'
+	}) {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		fingerprint := normalizeFingerprint(field)
+		if digest, err := hex.DecodeString(fingerprint); err != nil || len(digest) != 32 {
+			return nil, fmt.Errorf("invalid SHA-256 fingerprint: %s", field)
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	return fingerprints, nil
+}
+
+// CheckSigners returns an error if any of the signers' fingerprint is not listed in the allowed fingerprints.
+func (result Result) CheckSigners(allowedFingerprints []string) error {
+	allowed := map[string]bool{}
+	for _, fingerprint := range allowedFingerprints {
+		allowed[normalizeFingerprint(fingerprint)] = true
+	}
+
+	var unexpected []string
+	for _, signer := range result.Signers {
+		if !allowed[normalizeFingerprint(signer.SHA256Fingerprint)] {
+			unexpected = append(unexpected, fmt.Sprintf("%s (%s)", signer.SHA256Fingerprint, signer.Subject))
+		}
+	}
+	if len(unexpected) > 0 {
+		return fmt.Errorf("the APK is signed with an unexpected certificate: %s", strings.Join(unexpected, ", "))
+	}
+	return nil
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
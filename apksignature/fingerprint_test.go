@@ -0,0 +1,44 @@
+package apksignature
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testFingerprint = "AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89"
+
+func TestParseFingerprints(t *testing.T) {
+	// Given
+	other := strings.Repeat("0", 64)
+	input := testFingerprint + "\n " + other + " |\n"
+
+	// When
+	fingerprints, err := ParseFingerprints(input)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{strings.ToLower(strings.ReplaceAll(testFingerprint, ":", "")), other}, fingerprints)
+}
+
+func TestParseFingerprints_Invalid(t *testing.T) {
+	// When
+	_, err := ParseFingerprints("AB:CD")
+
+	// Then
+	require.EqualError(t, err, "invalid SHA-256 fingerprint: AB:CD")
+}
+
+func TestResult_CheckSigners(t *testing.T) {
+	// Given
+	result := Result{Signers: []Signer{{Subject: "CN=Release", SHA256Fingerprint: testFingerprint}}}
+
+	// When
+	allowedErr := result.CheckSigners([]string{strings.ToLower(testFingerprint)})
+	unexpectedErr := result.CheckSigners([]string{strings.Repeat("0", 64)})
+
+	// Then
+	require.NoError(t, allowedErr)
+	require.EqualError(t, unexpectedErr, "the APK is signed with an unexpected certificate: "+testFingerprint+" (CN=Release)")
+}
@@ -0,0 +1,60 @@
+package apksignature
+
+import (
+	"crypto"
+	"crypto/dsa" // DSA signed APKs still exist
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha1" // registers crypto.SHA1, used by v1 signatures
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// verifySignature verifies a signature over the signed data with the given public key.
+func verifySignature(publicKey crypto.PublicKey, algorithm signatureAlgorithm, signed, signature []byte) error {
+	if !algorithm.hash.Available() {
+		return fmt.Errorf("unsupported hash: %s", algorithm.hash)
+	}
+	h := algorithm.hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if algorithm.keyType != keyTypeRSA {
+			return errors.New("signature algorithm does not match the RSA public key")
+		}
+		if algorithm.pss {
+			return rsa.VerifyPSS(key, algorithm.hash, digest, signature, &rsa.PSSOptions{SaltLength: algorithm.hash.Size()})
+		}
+		return rsa.VerifyPKCS1v15(key, algorithm.hash, digest, signature)
+	case *ecdsa.PublicKey:
+		if algorithm.keyType != keyTypeECDSA {
+			return errors.New("signature algorithm does not match the EC public key")
+		}
+		if !ecdsa.VerifyASN1(key, digest, signature) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case *dsa.PublicKey:
+		if algorithm.keyType != keyTypeDSA {
+			return errors.New("signature algorithm does not match the DSA public key")
+		}
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+			return fmt.Errorf("invalid DSA signature: %w", err)
+		}
+		// DSA uses the leftmost bits of the digest, up to the size of Q
+		if max := (key.Q.BitLen() + 7) / 8; len(digest) > max {
+			digest = digest[:max]
+		}
+		if !dsa.Verify(key, digest, sig.R, sig.S) {
+			return errors.New("invalid DSA signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+}
@@ -0,0 +1,256 @@
+package apksignature
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Block IDs of the APK Signing Block, based on: https://source.android.com/docs/security/features/apksigning/v2#apk-signing-block
+const (
+	v2BlockID uint32 = 0x7109871a
+	v3BlockID uint32 = 0xf05368c0
+)
+
+const (
+	eocdSignature      = 0x06054b50
+	eocdMinSize        = 22
+	eocdMaxCommentSize = 0xffff
+	eocdCDSizeOffset   = 12
+	eocdCDOffsetOffset = 16
+
+	signingBlockMagic      = "APK Sig Block 42"
+	signingBlockFooterSize = 8 + len(signingBlockMagic)
+)
+
+// ErrNoSigningBlock is returned if the APK has no APK Signing Block (it is not signed with the v2+ scheme).
+var ErrNoSigningBlock = errors.New("no APK Signing Block found")
+
+// zipSections describes the layout of the APK's ZIP structure around the APK Signing Block.
+type zipSections struct {
+	// signingBlockOffset is the start of the APK Signing Block, equal to cdOffset if there is no block.
+	signingBlockOffset int64
+	cdOffset           int64
+	cdSize             int64
+	eocdOffset         int64
+	eocd               []byte
+}
+
+// signingBlock is a parsed APK Signing Block.
+type signingBlock struct {
+	sections zipSections
+	// pairs maps the block's ID-value pair IDs to their values.
+	pairs map[uint32][]byte
+}
+
+// readZipSections locates the End of Central Directory record and the central directory of an APK.
+func readZipSections(f io.ReaderAt, size int64) (zipSections, error) {
+	if size < eocdMinSize {
+		return zipSections{}, errors.New("file is too small to be an APK")
+	}
+
+	tailSize := int64(eocdMinSize + eocdMaxCommentSize)
+	if tailSize > size {
+		tailSize = size
+	}
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, size-tailSize); err != nil {
+		return zipSections{}, err
+	}
+
+	// The EOCD is the last record, its comment runs until the end of the file.
+	for i := len(tail) - eocdMinSize; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) != eocdSignature {
+			continue
+		}
+		commentSize := int(binary.LittleEndian.Uint16(tail[i+20:]))
+		if i+eocdMinSize+commentSize != len(tail) {
+			continue
+		}
+
+		eocd := append([]byte{}, tail[i:]...)
+		cdSize := int64(binary.LittleEndian.Uint32(eocd[eocdCDSizeOffset:]))
+		cdOffset := int64(binary.LittleEndian.Uint32(eocd[eocdCDOffsetOffset:]))
+		eocdOffset := size - tailSize + int64(i)
+		if cdOffset == 0xffffffff {
+			return zipSections{}, errors.New("ZIP64 APKs are not supported")
+		}
+		if cdOffset+cdSize != eocdOffset {
+			return zipSections{}, errors.New("central directory is not immediately followed by the End of Central Directory record")
+		}
+
+		return zipSections{
+			signingBlockOffset: cdOffset,
+			cdOffset:           cdOffset,
+			cdSize:             cdSize,
+			eocdOffset:         eocdOffset,
+			eocd:               eocd,
+		}, nil
+	}
+
+	return zipSections{}, errors.New("End of Central Directory record not found")
+}
+
+// readSigningBlock reads the APK Signing Block located right before the central directory.
+func readSigningBlock(f io.ReaderAt, size int64) (signingBlock, error) {
+	sections, err := readZipSections(f, size)
+	if err != nil {
+		return signingBlock{}, err
+	}
+
+	if sections.cdOffset < int64(signingBlockFooterSize) {
+		return signingBlock{sections: sections}, ErrNoSigningBlock
+	}
+	footer := make([]byte, signingBlockFooterSize)
+	if _, err := f.ReadAt(footer, sections.cdOffset-int64(signingBlockFooterSize)); err != nil {
+		return signingBlock{}, err
+	}
+	if string(footer[8:]) != signingBlockMagic {
+		return signingBlock{sections: sections}, ErrNoSigningBlock
+	}
+
+	// The size fields exclude the leading size field itself.
+	blockSize := int64(binary.LittleEndian.Uint64(footer))
+	blockOffset := sections.cdOffset - blockSize - 8
+	if blockSize < int64(signingBlockFooterSize) || blockOffset < 0 {
+		return signingBlock{}, fmt.Errorf("invalid APK Signing Block size: %d", blockSize)
+	}
+
+	block := make([]byte, blockSize+8)
+	if _, err := f.ReadAt(block, blockOffset); err != nil {
+		return signingBlock{}, err
+	}
+	if int64(binary.LittleEndian.Uint64(block)) != blockSize {
+		return signingBlock{}, errors.New("APK Signing Block size fields do not match")
+	}
+
+	pairs, err := parseSigningBlockPairs(block[8 : len(block)-signingBlockFooterSize])
+	if err != nil {
+		return signingBlock{}, err
+	}
+
+	sections.signingBlockOffset = blockOffset
+	return signingBlock{sections: sections, pairs: pairs}, nil
+}
+
+// signingBlockPair is an ID-value pair of the APK Signing Block.
+type signingBlockPair struct {
+	id    uint32
+	value []byte
+}
+
+// parseSigningBlockPairs parses the uint64 length prefixed ID-value pairs of the APK Signing Block.
+func parseSigningBlockPairs(data []byte) (map[uint32][]byte, error) {
+	list, err := parseSigningBlockPairList(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := map[uint32][]byte{}
+	for _, pair := range list {
+		pairs[pair.id] = pair.value
+	}
+	return pairs, nil
+}
+
+// parseSigningBlockPairList parses the ID-value pairs of the APK Signing Block, keeping their order.
+func parseSigningBlockPairList(data []byte) ([]signingBlockPair, error) {
+	var pairs []signingBlockPair
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated APK Signing Block pair")
+		}
+		pairSize := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		if pairSize < 4 || pairSize > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid APK Signing Block pair size: %d", pairSize)
+		}
+
+		pairs = append(pairs, signingBlockPair{id: binary.LittleEndian.Uint32(data), value: data[4:pairSize]})
+		data = data[pairSize:]
+	}
+	return pairs, nil
+}
+
+// encodeSigningBlock encodes an APK Signing Block holding the pairs.
+func encodeSigningBlock(pairs []signingBlockPair) []byte {
+	size := signingBlockFooterSize
+	for _, pair := range pairs {
+		size += 12 + len(pair.value)
+	}
+
+	block := binary.LittleEndian.AppendUint64(nil, uint64(size))
+	for _, pair := range pairs {
+		block = binary.LittleEndian.AppendUint64(block, uint64(4+len(pair.value)))
+		block = binary.LittleEndian.AppendUint32(block, pair.id)
+		block = append(block, pair.value...)
+	}
+	block = binary.LittleEndian.AppendUint64(block, uint64(size))
+	return append(block, signingBlockMagic...)
+}
+
+// openAPK opens an APK and returns its size.
+func openAPK(pth string) (*os.File, int64, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		if closeErr := f.Close(); closeErr != nil {
+			return nil, 0, fmt.Errorf("%s, close: %s", err, closeErr)
+		}
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// lengthPrefixed splits a uint32 length prefixed value from the beginning of data.
+func lengthPrefixed(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, errors.New("truncated length prefixed value")
+	}
+	size := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	if uint64(size) > uint64(len(data)) {
+		return nil, nil, fmt.Errorf("length prefixed value size (%d) exceeds the remaining data (%d)", size, len(data))
+	}
+	return data[:size], data[size:], nil
+}
+
+// lengthPrefixedSequence splits a uint32 length prefixed sequence of uint32 length prefixed values.
+func lengthPrefixedSequence(data []byte) ([][]byte, []byte, error) {
+	sequence, rest, err := lengthPrefixed(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var values [][]byte
+	for len(sequence) > 0 {
+		var value []byte
+		if value, sequence, err = lengthPrefixed(sequence); err != nil {
+			return nil, nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rest, nil
+}
+
+// uint32Value splits a little endian uint32 from the beginning of data.
+func uint32Value(data []byte) (uint32, []byte, error) {
+	if len(data) < 4 {
+		return 0, nil, errors.New("truncated uint32 value")
+	}
+	return binary.LittleEndian.Uint32(data), data[4:], nil
+}
+
+// eocdForDigest returns the EOCD record with its central directory offset pointing to the APK Signing Block,
+// as it is digested by the v2+ signature schemes.
+func (sections zipSections) eocdForDigest() []byte {
+	eocd := bytes.Clone(sections.eocd)
+	binary.LittleEndian.PutUint32(eocd[eocdCDOffsetOffset:], uint32(sections.signingBlockOffset))
+	return eocd
+}
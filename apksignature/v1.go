@@ -0,0 +1,461 @@
+package apksignature
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto"
+	_ "crypto/sha512" // registers crypto.SHA384 and crypto.SHA512
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path"
+	"strings"
+)
+
+const (
+	metaInfDir   = "META-INF/"
+	manifestName = "META-INF/MANIFEST.MF"
+)
+
+var signatureBlockExtensions = []string{".RSA", ".DSA", ".EC"}
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	digestAlgorithms = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+
+	// digestAttributePrefixes maps the digest attribute name prefixes of the manifest and the .SF files to their hash.
+	digestAttributePrefixes = map[string]crypto.Hash{
+		"SHA-512": crypto.SHA512,
+		"SHA-384": crypto.SHA384,
+		"SHA-256": crypto.SHA256,
+		"SHA1":    crypto.SHA1,
+	}
+)
+
+// PKCS #7 structures, based on: https://datatracker.ietf.org/doc/html/rfc2315
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// verifyV1 verifies the JAR signatures of an APK and returns the signers' certificates.
+// The signature block's signature over the .SF file, the .SF file's digests of the manifest sections
+// and the manifest's digests of the entries are verified.
+func verifyV1(r io.ReaderAt, size int64) ([]*x509.Certificate, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var signatureFiles []*zip.File
+	for _, file := range archive.File {
+		if strings.HasPrefix(file.Name, metaInfDir) && path.Ext(file.Name) == ".SF" {
+			signatureFiles = append(signatureFiles, file)
+		}
+	}
+	if len(signatureFiles) == 0 {
+		return nil, errNotSigned
+	}
+
+	manifestFile, ok := files[manifestName]
+	if !ok {
+		return nil, errors.New("no " + manifestName + " found")
+	}
+	manifest, err := readZipFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	sections := parseManifest(manifest)
+
+	var certificates []*x509.Certificate
+	for _, file := range signatureFiles {
+		base := strings.TrimSuffix(file.Name, ".SF")
+		var blockFile *zip.File
+		for _, ext := range signatureBlockExtensions {
+			if f, ok := files[base+ext]; ok {
+				blockFile = f
+				break
+			}
+		}
+		if blockFile == nil {
+			return nil, fmt.Errorf("no signature block found for: %s", file.Name)
+		}
+
+		certificate, err := verifyV1Signer(file, blockFile, manifest, sections)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", blockFile.Name, err)
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	if err := verifyEntries(archive.File, sections); err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
+
+var errNotSigned = errors.New("no JAR signature found")
+
+func verifyV1Signer(sfFile, blockFile *zip.File, manifest []byte, sections []manifestSection) (*x509.Certificate, error) {
+	sf, err := readZipFile(sfFile)
+	if err != nil {
+		return nil, err
+	}
+	block, err := readZipFile(blockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := verifyPKCS7(block, sf)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignatureFile(parseManifest(sf), manifest, sections); err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+// verifyPKCS7 verifies a detached PKCS #7 signature over the signed content, and returns the signer's certificate.
+func verifyPKCS7(block, signed []byte) (*x509.Certificate, error) {
+	var info contentInfo
+	if _, err := asn1.Unmarshal(block, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse signature block: %w", err)
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("unsupported signature block content type: %s", info.ContentType)
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("failed to parse signed data: %w", err)
+	}
+	certificates, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificates: %w", err)
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("no signer info")
+	}
+
+	signer := sd.SignerInfos[0]
+	var certificate *x509.Certificate
+	for _, c := range certificates {
+		if bytes.Equal(c.RawIssuer, signer.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(signer.IssuerAndSerialNumber.SerialNumber) == 0 {
+			certificate = c
+			break
+		}
+	}
+	if certificate == nil {
+		return nil, errors.New("signer certificate not found")
+	}
+
+	hash, ok := digestAlgorithms[signer.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm: %s", signer.DigestAlgorithm.Algorithm)
+	}
+
+	signedBytes := signed
+	if len(signer.AuthenticatedAttributes.FullBytes) > 0 {
+		if signedBytes, err = verifyAuthenticatedAttributes(signer.AuthenticatedAttributes, hash, signed); err != nil {
+			return nil, err
+		}
+	}
+
+	algorithm := signatureAlgorithm{hash: hash}
+	switch certificate.PublicKeyAlgorithm {
+	case x509.RSA:
+		algorithm.keyType = keyTypeRSA
+	case x509.ECDSA:
+		algorithm.keyType = keyTypeECDSA
+	case x509.DSA:
+		algorithm.keyType = keyTypeDSA
+	}
+	if err := verifySignature(certificate.PublicKey, algorithm, signedBytes, signer.EncryptedDigest); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %w", err)
+	}
+	return certificate, nil
+}
+
+// verifyAuthenticatedAttributes checks the messageDigest attribute against the signed content,
+// and returns the DER encoded attributes which are signed instead of the content.
+func verifyAuthenticatedAttributes(raw asn1.RawValue, hash crypto.Hash, content []byte) ([]byte, error) {
+	var attributes []attribute
+	if _, err := asn1.UnmarshalWithParams(raw.FullBytes, &attributes, "set,tag:0"); err != nil {
+		return nil, fmt.Errorf("failed to parse authenticated attributes: %w", err)
+	}
+
+	h := hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+
+	found := false
+	for _, attr := range attributes {
+		if !attr.Type.Equal(oidMessageDigest) {
+			continue
+		}
+		var messageDigest []byte
+		if _, err := asn1.Unmarshal(attr.Values.Bytes, &messageDigest); err != nil {
+			return nil, fmt.Errorf("failed to parse message digest: %w", err)
+		}
+		if !bytes.Equal(messageDigest, digest) {
+			return nil, errors.New("message digest mismatch")
+		}
+		found = true
+	}
+	if !found {
+		return nil, errors.New("no message digest attribute")
+	}
+
+	// The attributes are signed with their universal SET tag instead of the implicit [0] tag.
+	signed := append([]byte{}, raw.FullBytes...)
+	signed[0] = 0x31
+	return signed, nil
+}
+
+// verifySignatureFile checks the .SF file's digests of the whole manifest and of its main section, if present,
+// and the digest of every manifest entry section, which all have to be listed in the .SF file.
+func verifySignatureFile(sf []manifestSection, manifest []byte, sections []manifestSection) error {
+	if len(sf) == 0 || len(sections) == 0 {
+		return errors.New("empty signature file or manifest")
+	}
+
+	if name, err := verifyDigests(sf[0].attributes, "-Digest-Manifest", bytes.NewReader(manifest)); err != nil {
+		return err
+	} else if name != "" {
+		return fmt.Errorf("%s mismatch, the manifest was modified after signing", name)
+	}
+	if name, err := verifyDigests(sf[0].attributes, "-Digest-Manifest-Main-Attributes", bytes.NewReader(sections[0].raw)); err != nil {
+		return err
+	} else if name != "" {
+		return fmt.Errorf("%s mismatch, the manifest main attributes were modified after signing", name)
+	}
+
+	sfSections := map[string]manifestSection{}
+	for _, section := range sf[1:] {
+		sfSections[section.name] = section
+	}
+	for _, section := range sections[1:] {
+		sfSection, ok := sfSections[section.name]
+		if !ok {
+			return fmt.Errorf("%s is not listed in the signature file", section.name)
+		}
+		name, err := verifyDigests(sfSection.attributes, "-Digest", bytes.NewReader(section.raw))
+		if err != nil {
+			return fmt.Errorf("%s: %w", section.name, err)
+		}
+		if name != "" {
+			return fmt.Errorf("%s: %s mismatch, the manifest section was modified after signing", section.name, name)
+		}
+	}
+	return nil
+}
+
+// verifyEntries checks the manifest's digest of every entry, and that every entry apart from the JAR signature
+// files is listed in the manifest.
+func verifyEntries(files []*zip.File, sections []manifestSection) error {
+	entries := map[string]manifestSection{}
+	for _, section := range sections[1:] {
+		entries[section.name] = section
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file.Name, "/") || isSignatureEntry(file.Name) {
+			continue
+		}
+		section, ok := entries[file.Name]
+		if !ok {
+			return fmt.Errorf("%s is not listed in the manifest, the entry was added after signing", file.Name)
+		}
+		delete(entries, file.Name)
+
+		r, err := file.Open()
+		if err != nil {
+			return err
+		}
+		name, err := verifyDigests(section.attributes, "-Digest", r)
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		if name != "" {
+			return fmt.Errorf("%s: %s mismatch, the entry was modified after signing", file.Name, name)
+		}
+	}
+	for name := range entries {
+		return fmt.Errorf("%s is listed in the manifest but missing from the APK", name)
+	}
+	return nil
+}
+
+// isSignatureEntry reports whether the entry is the manifest or a JAR signature file, which are not listed in the manifest.
+func isSignatureEntry(name string) bool {
+	if !strings.HasPrefix(name, metaInfDir) || strings.Contains(name[len(metaInfDir):], "/") {
+		return false
+	}
+	if name == manifestName {
+		return true
+	}
+	switch strings.ToUpper(path.Ext(name)) {
+	case ".SF", ".RSA", ".DSA", ".EC":
+		return true
+	}
+	return strings.HasPrefix(strings.ToUpper(name[len(metaInfDir):]), "SIG-")
+}
+
+// verifyDigests compares the supported digest attributes with the given name suffix against the content,
+// and returns the name of the first mismatching attribute.
+// The "-Digest" attributes of the entry sections are required, the others are only checked if present.
+func verifyDigests(attributes map[string]string, suffix string, content io.Reader) (string, error) {
+	hashes := map[string]hash.Hash{}
+	var writers []io.Writer
+	for prefix, algorithm := range digestAttributePrefixes {
+		if _, ok := attributes[prefix+suffix]; !ok {
+			continue
+		}
+		h := algorithm.New()
+		hashes[prefix+suffix] = h
+		writers = append(writers, h)
+	}
+	if len(hashes) == 0 {
+		if suffix == "-Digest" {
+			return "", errors.New("no supported digest attribute")
+		}
+		return "", nil
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), content); err != nil {
+		return "", err
+	}
+
+	for name, h := range hashes {
+		if base64.StdEncoding.EncodeToString(h.Sum(nil)) != attributes[name] {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// manifestSection is a section of a manifest style file, raw holds its bytes including the terminating empty line.
+type manifestSection struct {
+	name       string
+	attributes map[string]string
+	raw        []byte
+}
+
+// parseManifest splits a manifest style file into its sections, the first one is the main section.
+func parseManifest(content []byte) []manifestSection {
+	var sections []manifestSection
+	start := 0
+	for start < len(content) {
+		end := start
+		for end < len(content) {
+			lineEnd := bytes.IndexByte(content[end:], '\n')
+			if lineEnd < 0 {
+				end = len(content)
+				break
+			}
+			line := content[end : end+lineEnd]
+			end += lineEnd + 1
+			if len(bytes.TrimRight(line, "\r")) == 0 {
+				break
+			}
+		}
+
+		raw := content[start:end]
+		start = end
+		if len(bytes.TrimSpace(raw)) == 0 && len(sections) > 0 {
+			continue
+		}
+		attributes := parseAttributes(raw)
+		sections = append(sections, manifestSection{name: attributes["Name"], attributes: attributes, raw: raw})
+	}
+	return sections
+}
+
+// parseAttributes parses the attributes of a manifest section, joining the continuation lines.
+func parseAttributes(section []byte) map[string]string {
+	attributes := map[string]string{}
+	last := ""
+	scanner := bufio.NewScanner(bytes.NewReader(section))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, " ") && last != "" {
+			attributes[last] += line[1:]
+			continue
+		}
+		if idx := strings.Index(line, ": "); idx > 0 {
+			last = line[:idx]
+			attributes[last] = line[idx+2:]
+		}
+	}
+	return attributes
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadAll(r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	return content, err
+}
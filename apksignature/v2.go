@@ -0,0 +1,257 @@
+package apksignature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Signature algorithm IDs, based on: https://source.android.com/docs/security/features/apksigning/v2#signature-algorithm-ids
+const (
+	sigRSAPSSSHA256   uint32 = 0x0101
+	sigRSAPSSSHA512   uint32 = 0x0102
+	sigRSAPKCS1SHA256 uint32 = 0x0103
+	sigRSAPKCS1SHA512 uint32 = 0x0104
+	sigECDSASHA256    uint32 = 0x0201
+	sigECDSASHA512    uint32 = 0x0202
+	sigDSASHA256      uint32 = 0x0301
+)
+
+const contentDigestChunkSize = 1024 * 1024
+
+// signatureAlgorithm describes how a v2+ signature and the matching content digest are computed.
+type signatureAlgorithm struct {
+	hash     crypto.Hash
+	keyType  keyType
+	pss      bool
+	strength int
+}
+
+type keyType int
+
+const (
+	keyTypeRSA keyType = iota
+	keyTypeECDSA
+	keyTypeDSA
+)
+
+var signatureAlgorithms = map[uint32]signatureAlgorithm{
+	sigRSAPSSSHA256:   {hash: crypto.SHA256, keyType: keyTypeRSA, pss: true, strength: 1},
+	sigRSAPSSSHA512:   {hash: crypto.SHA512, keyType: keyTypeRSA, pss: true, strength: 2},
+	sigRSAPKCS1SHA256: {hash: crypto.SHA256, keyType: keyTypeRSA, strength: 1},
+	sigRSAPKCS1SHA512: {hash: crypto.SHA512, keyType: keyTypeRSA, strength: 2},
+	sigECDSASHA256:    {hash: crypto.SHA256, keyType: keyTypeECDSA, strength: 1},
+	sigECDSASHA512:    {hash: crypto.SHA512, keyType: keyTypeECDSA, strength: 2},
+	sigDSASHA256:      {hash: crypto.SHA256, keyType: keyTypeDSA, strength: 1},
+}
+
+// verifyV2Block verifies the signers of a v2 or v3 signature scheme block, and returns their certificates.
+func verifyV2Block(r io.ReaderAt, sections zipSections, block []byte, v3 bool) ([]*x509.Certificate, error) {
+	signers, rest, err := lengthPrefixedSequence(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("unexpected data after signers")
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no signers")
+	}
+
+	digests := map[crypto.Hash][]byte{}
+	var certificates []*x509.Certificate
+	for i, signer := range signers {
+		certificate, err := verifyV2Signer(r, sections, signer, v3, digests)
+		if err != nil {
+			return nil, fmt.Errorf("signer #%d: %w", i+1, err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, nil
+}
+
+// verifyV2Signer verifies a signer's signature over its signed data and the APK content digest,
+// returns the signer's certificate. Content digests are cached by hash in digests.
+func verifyV2Signer(r io.ReaderAt, sections zipSections, signer []byte, v3 bool, digests map[crypto.Hash][]byte) (*x509.Certificate, error) {
+	signedData, rest, err := lengthPrefixed(signer)
+	if err != nil {
+		return nil, err
+	}
+	if v3 {
+		// minSdkVersion and maxSdkVersion of the signer
+		if _, rest, err = uint32Value(rest); err != nil {
+			return nil, err
+		}
+		if _, rest, err = uint32Value(rest); err != nil {
+			return nil, err
+		}
+	}
+	signatures, rest, err := lengthPrefixedSequence(rest)
+	if err != nil {
+		return nil, err
+	}
+	publicKeyDER, _, err := lengthPrefixed(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(publicKeyDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	// Verify the strongest supported signature
+	algorithmID, signature, err := strongestSignature(signatures)
+	if err != nil {
+		return nil, err
+	}
+	algorithm := signatureAlgorithms[algorithmID]
+	if err := verifySignature(publicKey, algorithm, signedData, signature); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	digestsData, rest, err := lengthPrefixedSequence(signedData)
+	if err != nil {
+		return nil, err
+	}
+	certificatesData, _, err := lengthPrefixedSequence(rest)
+	if err != nil {
+		return nil, err
+	}
+	if len(certificatesData) == 0 {
+		return nil, errors.New("no certificates")
+	}
+
+	certificate, err := x509.ParseCertificate(certificatesData[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	if !bytes.Equal(certificate.RawSubjectPublicKeyInfo, publicKeyDER) {
+		return nil, errors.New("public key does not match the certificate")
+	}
+
+	expectedDigest, err := signedDigest(digestsData, algorithmID)
+	if err != nil {
+		return nil, err
+	}
+	digest, ok := digests[algorithm.hash]
+	if !ok {
+		if digest, err = contentDigest(r, sections, algorithm.hash); err != nil {
+			return nil, err
+		}
+		digests[algorithm.hash] = digest
+	}
+	if !bytes.Equal(digest, expectedDigest) {
+		return nil, errors.New("APK content digest mismatch, the APK was modified after signing")
+	}
+
+	return certificate, nil
+}
+
+// strongestSignature returns the signature made with the strongest supported algorithm.
+func strongestSignature(signatures [][]byte) (uint32, []byte, error) {
+	bestID := uint32(0)
+	var best []byte
+	for _, signature := range signatures {
+		id, rest, err := uint32Value(signature)
+		if err != nil {
+			return 0, nil, err
+		}
+		value, _, err := lengthPrefixed(rest)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		algorithm, ok := signatureAlgorithms[id]
+		if !ok {
+			continue
+		}
+		if best == nil || algorithm.strength > signatureAlgorithms[bestID].strength {
+			bestID, best = id, value
+		}
+	}
+
+	if best == nil {
+		return 0, nil, errors.New("no supported signature algorithm")
+	}
+	return bestID, best, nil
+}
+
+// signedDigest returns the content digest listed in the signed data for the given signature algorithm.
+func signedDigest(digests [][]byte, algorithmID uint32) ([]byte, error) {
+	for _, digest := range digests {
+		id, rest, err := uint32Value(digest)
+		if err != nil {
+			return nil, err
+		}
+		value, _, err := lengthPrefixed(rest)
+		if err != nil {
+			return nil, err
+		}
+		if id == algorithmID {
+			return value, nil
+		}
+	}
+	return nil, fmt.Errorf("no digest for signature algorithm: 0x%04x", algorithmID)
+}
+
+// contentDigest computes the chunked digest of the APK's ZIP entries, central directory and EOCD record,
+// based on: https://source.android.com/docs/security/features/apksigning/v2#integrity-protected-contents
+func contentDigest(r io.ReaderAt, sections zipSections, hashType crypto.Hash) ([]byte, error) {
+	newHash := func() hash.Hash {
+		if hashType == crypto.SHA512 {
+			return sha512.New()
+		}
+		return sha256.New()
+	}
+
+	var chunkDigests []byte
+	chunkCount := 0
+	digestChunks := func(section io.Reader) error {
+		chunk := make([]byte, contentDigestChunkSize)
+		for {
+			n, err := io.ReadFull(section, chunk)
+			if n > 0 {
+				h := newHash()
+				prefix := make([]byte, 5)
+				prefix[0] = 0xa5
+				binary.LittleEndian.PutUint32(prefix[1:], uint32(n))
+				h.Write(prefix)
+				h.Write(chunk[:n])
+				chunkDigests = h.Sum(chunkDigests)
+				chunkCount++
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, section := range []io.Reader{
+		io.NewSectionReader(r, 0, sections.signingBlockOffset),
+		io.NewSectionReader(r, sections.cdOffset, sections.cdSize),
+		bytes.NewReader(sections.eocdForDigest()),
+	} {
+		if err := digestChunks(section); err != nil {
+			return nil, err
+		}
+	}
+
+	h := newHash()
+	prefix := make([]byte, 5)
+	prefix[0] = 0x5a
+	binary.LittleEndian.PutUint32(prefix[1:], uint32(chunkCount))
+	h.Write(prefix)
+	h.Write(chunkDigests)
+	return h.Sum(nil), nil
+}
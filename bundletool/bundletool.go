@@ -1,9 +1,14 @@
 package bundletool
 
 import (
+	"fmt"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-io/go-utils/urlutil"
 )
@@ -14,6 +19,8 @@ type KeystoreConfig struct {
 	Path string
 	// If you’re specifying a password in plain text, qualify it with pass:.
 	// If you’re passing the path to a file that contains the password, qualify it with file:.
+	// Unqualified passwords are used as is, see PasswordSourceFromArg.
+	// See PasswordSource.Arg for keeping the password off the command line.
 	KeystorePassword string
 	// Specifies the alias of the signing key you want to use.
 	SigningKeyAlias string
@@ -26,11 +33,20 @@ const (
 	// GithubReleaseBaseURL ...
 	GithubReleaseBaseURL = "https://github.com/google/bundletool/releases/download"
 	bundletoolAllJarName = "bundletool-all.jar"
+	jarExtension         = ".jar"
 )
 
 // Tool represent a wrapper around the bundletool.
 type Tool struct {
-	path string
+	path    string
+	version string
+	// launcher is set if path points to an executable wrapping bundletool (for example the Homebrew script),
+	// instead of the bundletool jar.
+	launcher bool
+	// java is the path of the Java runtime running the bundletool jar, the one on the PATH is used if empty.
+	java string
+	// jvmOptions configure the JVM running bundletool.
+	jvmOptions JVMOptions
 }
 
 // Path return the file path where bundletool is located.
@@ -38,11 +54,21 @@ func (tool Tool) Path() string {
 	return tool.path
 }
 
+// IsLauncher returns true if bundletool is run by a launcher selecting its own Java runtime, instead of a jar.
+func (tool Tool) IsLauncher() bool {
+	return tool.launcher
+}
+
 // FileDownloader is a type that can download a file with fallback URLs
 type FileDownloader interface {
 	GetWithFallback(destination, source string, fallbackSources ...string) error
 }
 
+// Version returns the bundletool version, empty if unknown.
+func (tool Tool) Version() string {
+	return tool.version
+}
+
 // New downloads the bundletool executable from Github and places it to a temporary path.
 func New(version string, downloader FileDownloader, baseURL string) (*Tool, error) {
 	tmpPth, err := pathutil.NormalizedOSTempDirPath("tool")
@@ -50,34 +76,167 @@ func New(version string, downloader FileDownloader, baseURL string) (*Tool, erro
 		return nil, err
 	}
 
-	toolPath := filepath.Join(tmpPth, bundletoolAllJarName)
+	return NewCached(version, "", NewCache(tmpPth), downloader, baseURL)
+}
+
+// NewCached returns the bundletool executable from the cache, downloading it from Github if it is missing.
+// The jar is verified against the provided SHA-256 checksum, or the pinned one if empty.
+// Cache entries failing the verification are evicted and downloaded again.
+func NewCached(version, checksum string, cache Cache, downloader FileDownloader, baseURL string) (*Tool, error) {
+	expected := expectedChecksum(version, checksum)
+
+	if toolPath, ok := cache.lookup(version, expected); ok {
+		log.Infof("Using cached bundletool %s", version)
+		return &Tool{path: toolPath, version: version}, nil
+	}
 
 	sources, err := sources(version, baseURL)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := downloader.GetWithFallback(toolPath, sources[0], sources[1:]...); err != nil {
+	if err := os.MkdirAll(filepath.Dir(cache.jarPath(version)), 0755); err != nil {
+		return nil, err
+	}
+
+	downloadPath := cache.jarPath(version) + ".download"
+	if err := downloader.GetWithFallback(downloadPath, sources[0], sources[1:]...); err != nil {
 		return nil, err
 	}
 
-	return &Tool{toolPath}, err
+	toolPath, err := cache.store(version, expected, downloadPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tool{path: toolPath, version: version}, nil
+}
+
+// NewLocal returns a Tool using a preinstalled bundletool, without downloading anything.
+// The provided path either points to a bundletool jar, or to a bundletool launcher:
+// a launcher can be referenced by its path or by its name if it is on the PATH.
+func NewLocal(pth string) (*Tool, error) {
+	if strings.HasSuffix(pth, jarExtension) {
+		absPth, err := pathutil.AbsPath(pth)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(absPth); err != nil {
+			return nil, fmt.Errorf("bundletool jar not found at: %s: %w", absPth, err)
+		}
+		return &Tool{path: absPth}, nil
+	}
+
+	launcherPth, err := exec.LookPath(pth)
+	if err != nil {
+		return nil, fmt.Errorf("bundletool launcher not found: %w", err)
+	}
+	return &Tool{path: launcherPth, launcher: true}, nil
 }
 
 // BuildCommand returns a command.Model with the provided command and arguments that will be
 // executed by bundletool.
 func (tool Tool) BuildCommand(cmd string, args ...string) *command.Model {
-	return command.New("java", append([]string{"-jar", string(tool.path), cmd}, args...)...)
+	if tool.launcher {
+		launcherCmd := command.New(tool.path, append([]string{cmd}, args...)...)
+		if len(tool.jvmOptions.args()) > 0 {
+			launcherCmd.AppendEnvs(tool.jvmOptions.javaToolOptionsEnv())
+		}
+		return launcherCmd
+	}
+
+	java := tool.java
+	if java == "" {
+		java = "java"
+	}
+	javaArgs := append(tool.jvmOptions.args(), "-jar", string(tool.path), cmd)
+	return command.New(java, append(javaArgs, args...)...)
+}
+
+// Mode is the build-apks mode, defining the kind of APKs generated from the bundle.
+type Mode string
+
+// build-apks modes, based on: https://developer.android.com/tools/bundletool#generate_apks
+const (
+	// ModeDefault generates split APKs for each device configuration.
+	ModeDefault Mode = "default"
+	// ModeUniversal generates a single APK containing all code and resources.
+	ModeUniversal Mode = "universal"
+	// ModeSystem generates APKs for system images.
+	ModeSystem Mode = "system"
+	// ModeInstant generates instant app APKs.
+	ModeInstant Mode = "instant"
+	// ModeArchive generates an archived APK.
+	ModeArchive Mode = "archive"
+)
+
+var modes = []Mode{ModeDefault, ModeUniversal, ModeSystem, ModeInstant, ModeArchive}
+
+// ParseMode parses a build-apks mode.
+func ParseMode(s string) (Mode, error) {
+	for _, mode := range modes {
+		if string(mode) == s {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown build-apks mode: %s", s)
+}
+
+// BuildAPKsOptions represents the optional parameters of the build-apks command.
+type BuildAPKsOptions struct {
+	// Mode defines the kind of generated APKs, ModeDefault is used if empty.
+	Mode Mode
+	// Modules limits the generated APKs to the given modules.
+	Modules []string
+	// OptimizeFor limits the split dimensions, for example: abi, screen_density or language.
+	OptimizeFor string
+	// LocalTesting generates APKs for testing with internal app sharing like behaviour.
+	LocalTesting bool
+	// Overwrite allows overwriting an existing output file.
+	Overwrite bool
+}
+
+// modeArg returns the --mode argument of the build-apks command.
+func (opts BuildAPKsOptions) modeArg() string {
+	if opts.Mode == "" {
+		return "--mode=" + string(ModeDefault)
+	}
+	return "--mode=" + string(opts.Mode)
+}
+
+// args returns the build-apks arguments of the options, except the mode.
+func (opts BuildAPKsOptions) args() []string {
+	var args []string
+	if len(opts.Modules) > 0 {
+		args = append(args, "--modules="+strings.Join(opts.Modules, ","))
+	}
+	if opts.OptimizeFor != "" {
+		args = append(args, "--optimize-for="+opts.OptimizeFor)
+	}
+	if opts.LocalTesting {
+		args = append(args, "--local-testing")
+	}
+	if opts.Overwrite {
+		args = append(args, "--overwrite")
+	}
+	return args
 }
 
 // BuildAPKs generates an universal .apks file from the provided .aab file.
 // KeystoreConfig is optinal to provide. If provided than the returned .apks will be signed with it.
 // If not provided then bundletool will try to use the debug.keystore.
 func (tool Tool) BuildAPKs(aabPath, apksPath string, keystoreCfg *KeystoreConfig) *command.Model {
+	return tool.BuildAPKsWithOptions(aabPath, apksPath, BuildAPKsOptions{Mode: ModeUniversal}, keystoreCfg)
+}
+
+// BuildAPKsWithOptions generates an .apks file from the provided .aab file, according to the given options.
+// KeystoreConfig is optinal to provide, see BuildAPKs.
+func (tool Tool) BuildAPKsWithOptions(aabPath, apksPath string, opts BuildAPKsOptions, keystoreCfg *KeystoreConfig) *command.Model {
 	args := []string{}
-	args = append(args, "--mode=universal")
+	args = append(args, opts.modeArg())
 	args = append(args, "--bundle", aabPath)
 	args = append(args, "--output", apksPath)
+	args = append(args, opts.args()...)
 
 	if keystoreCfg != nil {
 		args = append(args, "--ks", keystoreCfg.Path)
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
 	logv2 "github.com/bitrise-io/go-utils/v2/log"
@@ -52,6 +53,55 @@ func Test_BuildCommand(t *testing.T) {
 	require.Equal(t, expectedCommand, actualCommand)
 }
 
+func Test_BuildCommand_Launcher(t *testing.T) {
+	// Given
+	tool := Tool{path: "/usr/local/bin/bundletool", launcher: true}
+	expectedCommand := []string{"/usr/local/bin/bundletool", "command", "arg1", "arg2"}
+
+	// When
+	actualCommand := tool.BuildCommand("command", "arg1", "arg2").GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_NewLocal_Jar(t *testing.T) {
+	// Given
+	jarPath := filepath.Join(t.TempDir(), "bundletool.jar")
+	require.NoError(t, os.WriteFile(jarPath, []byte(jarContent), 0644))
+
+	// When
+	tool, err := NewLocal(jarPath)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, Tool{path: jarPath}, *tool)
+}
+
+func Test_NewLocal_MissingJar(t *testing.T) {
+	// When
+	tool, err := NewLocal(filepath.Join(t.TempDir(), "bundletool.jar"))
+
+	// Then
+	require.Error(t, err)
+	require.Nil(t, tool)
+}
+
+func Test_NewLocal_LauncherOnPath(t *testing.T) {
+	// Given
+	binDir := t.TempDir()
+	launcherPath := filepath.Join(binDir, "bundletool")
+	require.NoError(t, os.WriteFile(launcherPath, []byte("#!/bin/sh\n"), 0755))
+	t.Setenv("PATH", binDir)
+
+	// When
+	tool, err := NewLocal("bundletool")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, Tool{path: launcherPath, launcher: true}, *tool)
+}
+
 func Test_BuildAPKs_withoutKeystoreConfig(t *testing.T) {
 	// Given
 	tool := givenTool()
@@ -83,6 +133,52 @@ func Test_BuildAPKs_withKeystoreConfig(t *testing.T) {
 	require.Equal(t, expectedCommand, actualCommand)
 }
 
+func Test_BuildAPKsWithOptions(t *testing.T) {
+	// Given
+	tool := givenTool()
+	aabPath := "/path/to/app.aab"
+	apksPath := "/path/to/app.apks"
+	opts := BuildAPKsOptions{
+		Mode:         ModeDefault,
+		Modules:      []string{"base", "feature"},
+		OptimizeFor:  "abi",
+		LocalTesting: true,
+		Overwrite:    true,
+	}
+	expectedCommand := []string{"java", "-jar", tool.path, "build-apks", "--mode=default", "--bundle", aabPath, "--output", apksPath,
+		"--modules=base,feature", "--optimize-for=abi", "--local-testing", "--overwrite"}
+
+	// When
+	actualCommand := tool.BuildAPKsWithOptions(aabPath, apksPath, opts, nil).GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_BuildAPKsWithOptions_DefaultMode(t *testing.T) {
+	// Given
+	tool := givenTool()
+	expectedCommand := []string{"java", "-jar", tool.path, "build-apks", "--mode=default", "--bundle", "app.aab", "--output", "app.apks"}
+
+	// When
+	actualCommand := tool.BuildAPKsWithOptions("app.aab", "app.apks", BuildAPKsOptions{}, nil).GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_ParseMode(t *testing.T) {
+	for _, mode := range []Mode{ModeDefault, ModeUniversal, ModeSystem, ModeInstant, ModeArchive} {
+		actual, err := ParseMode(string(mode))
+
+		require.NoError(t, err)
+		require.Equal(t, mode, actual)
+	}
+
+	_, err := ParseMode("split")
+	require.EqualError(t, err, "unknown build-apks mode: split")
+}
+
 func Test_sources(t *testing.T) {
 	// Given
 	version := "0.1.0"
@@ -132,11 +228,14 @@ type MockFileDownloader struct {
 
 func (m *MockFileDownloader) GetWithFallback(destination, source string, fallbackSources ...string) error {
 	args := m.Called(destination, source, fallbackSources)
-	return args.Error(0)
+	if err := args.Error(0); err != nil {
+		return err
+	}
+	return os.WriteFile(destination, []byte(jarContent), 0644)
 }
 
 func givenTool() Tool {
-	return Tool{"/whatever/path"}
+	return Tool{path: "/whatever/path"}
 }
 
 func givenKeystoreConfig() KeystoreConfig {
@@ -0,0 +1,177 @@
+package bundletool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const checksumFileExtension = ".sha256"
+
+// knownChecksums pins the SHA-256 digest of the bundletool-all jar for bundletool versions.
+// Google does not publish checksums for the release assets, an entry has to be computed from a jar
+// downloaded from https://github.com/google/bundletool/releases and checked out of band.
+// Versions missing from this table are verified against the explicitly provided checksum. Without one the first
+// download is not verified (or fails, see Cache.WithRequiredChecksum), later runs are verified against the digest
+// recorded at download time.
+var knownChecksums = map[string]string{}
+
+// Cache stores downloaded bundletool jars in a persistent directory, keyed by version.
+type Cache struct {
+	dir             string
+	requireChecksum bool
+}
+
+// NewCache creates a Cache rooted at the given directory.
+func NewCache(dir string) Cache {
+	return Cache{dir: dir}
+}
+
+// DefaultCacheDir returns the user level cache directory used for bundletool jars.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bundletool"), nil
+}
+
+// WithRequiredChecksum returns a copy of the cache which refuses jars without a pinned or explicitly provided checksum,
+// instead of trusting the first download.
+func (cache Cache) WithRequiredChecksum() Cache {
+	cache.requireChecksum = true
+	return cache
+}
+
+// Dir returns the cache's root directory.
+func (cache Cache) Dir() string {
+	return cache.dir
+}
+
+// jarPath returns the location of the given bundletool version's jar inside the cache.
+func (cache Cache) jarPath(version string) string {
+	return filepath.Join(cache.dir, version, bundletoolAllJarName)
+}
+
+// expectedChecksum returns the digest a given bundletool version's jar has to match.
+// The explicitly provided checksum takes precedence over the pinned one.
+func expectedChecksum(version, checksum string) string {
+	if checksum = normalizedChecksum(checksum); checksum != "" {
+		return checksum
+	}
+	return knownChecksums[version]
+}
+
+// normalizedChecksum lowercases a hex digest and drops the optional `sha256:` prefix.
+func normalizedChecksum(checksum string) string {
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	return strings.TrimPrefix(checksum, "sha256:")
+}
+
+// lookup returns the cached jar path for the given version if it exists and passes the integrity check.
+// Cache entries failing the check are evicted.
+func (cache Cache) lookup(version, expected string) (string, bool) {
+	pth := cache.jarPath(version)
+	if _, err := os.Stat(pth); err != nil {
+		return "", false
+	}
+
+	actual, err := fileChecksum(pth)
+	if err != nil {
+		log.Warnf("Failed to calculate checksum of cached bundletool (%s): %s", pth, err)
+		cache.evict(version)
+		return "", false
+	}
+
+	if expected == "" {
+		if cache.requireChecksum {
+			return "", false
+		}
+		// No pinned digest, fall back to the one recorded at download time.
+		recorded, err := ioutil.ReadFile(pth + checksumFileExtension)
+		if err != nil {
+			log.Warnf("No checksum recorded for cached bundletool (%s), downloading it again", pth)
+			cache.evict(version)
+			return "", false
+		}
+		expected = normalizedChecksum(string(recorded))
+	}
+
+	if actual != expected {
+		log.Warnf("Cached bundletool (%s) checksum mismatch, expected: %s, actual: %s", pth, expected, actual)
+		cache.evict(version)
+		return "", false
+	}
+
+	return pth, true
+}
+
+// store moves a downloaded jar into the cache after verifying its checksum.
+func (cache Cache) store(version, expected, downloadedPth string) (string, error) {
+	actual, err := fileChecksum(downloadedPth)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case expected == "" && cache.requireChecksum:
+		removeDownload(downloadedPth)
+		return "", fmt.Errorf("no checksum is known for bundletool %s, the downloaded jar's SHA-256 is: %s", version, actual)
+	case expected != "" && actual != expected:
+		removeDownload(downloadedPth)
+		return "", fmt.Errorf("downloaded bundletool %s checksum mismatch, expected: %s, actual: %s", version, expected, actual)
+	case expected == "":
+		log.Warnf("WARNING: no checksum is known for bundletool %s, the downloaded jar is NOT verified.", version)
+		log.Warnf("Its SHA-256 is recorded and checked by later runs: %s", actual)
+		log.Warnf("Verify it against a trusted copy and set it as the expected checksum to pin it.")
+	}
+
+	pth := cache.jarPath(version)
+	if err := os.Rename(downloadedPth, pth); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(pth+checksumFileExtension, []byte(actual+"\n"), 0644); err != nil {
+		return "", err
+	}
+
+	return pth, nil
+}
+
+func removeDownload(pth string) {
+	if err := os.Remove(pth); err != nil {
+		log.Warnf("Failed to remove downloaded bundletool (%s): %s", pth, err)
+	}
+}
+
+// evict removes the given version's jar and its recorded checksum from the cache.
+func (cache Cache) evict(version string) {
+	if err := os.RemoveAll(filepath.Dir(cache.jarPath(version))); err != nil {
+		log.Warnf("Failed to evict bundletool %s from cache: %s", version, err)
+	}
+}
+
+// fileChecksum returns the hex encoded SHA-256 digest of a file.
+func fileChecksum(pth string) (string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Errorf("Failed to close file, error: %s", err)
+		}
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,162 @@
+package bundletool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	jarContent = "bundletool-all.jar content"
+	// sha256 of jarContent
+	jarChecksum = "05def5648daa6935db6236e7b8e8f47d9594e5b3c4aa581f31ac2f87d37648bf"
+)
+
+func Test_NewCached_DownloadsAndRecordsChecksum(t *testing.T) {
+	// Given
+	cache := NewCache(t.TempDir())
+	downloader := givenMockFileDownloader()
+
+	// When
+	tool, err := NewCached("1.0.0", "", cache, downloader, GithubReleaseBaseURL)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(cache.Dir(), "1.0.0", bundletoolAllJarName), tool.Path())
+	require.Equal(t, "1.0.0", tool.Version())
+	assertFileExists(t, tool.Path()+checksumFileExtension)
+	downloader.AssertNumberOfCalls(t, "GetWithFallback", 1)
+}
+
+func Test_NewCached_UsesCache(t *testing.T) {
+	// Given
+	cache := NewCache(t.TempDir())
+	downloader := givenMockFileDownloader()
+	_, err := NewCached("1.0.0", "", cache, downloader, GithubReleaseBaseURL)
+	require.NoError(t, err)
+
+	// When
+	tool, err := NewCached("1.0.0", "", cache, downloader, GithubReleaseBaseURL)
+
+	// Then
+	require.NoError(t, err)
+	require.NotNil(t, tool)
+	downloader.AssertNumberOfCalls(t, "GetWithFallback", 1)
+}
+
+func Test_NewCached_EvictsCorruptedEntry(t *testing.T) {
+	// Given
+	cache := NewCache(t.TempDir())
+	downloader := givenMockFileDownloader()
+	tool, err := NewCached("1.0.0", "", cache, downloader, GithubReleaseBaseURL)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(tool.Path(), []byte("tampered"), 0644))
+
+	// When
+	tool, err = NewCached("1.0.0", "", cache, downloader, GithubReleaseBaseURL)
+
+	// Then
+	require.NoError(t, err)
+	content, err := os.ReadFile(tool.Path())
+	require.NoError(t, err)
+	require.Equal(t, jarContent, string(content))
+	downloader.AssertNumberOfCalls(t, "GetWithFallback", 2)
+}
+
+func Test_NewCached_EvictsEntryNotMatchingExpectedChecksum(t *testing.T) {
+	// Given
+	cache := NewCache(t.TempDir())
+	jarPath := cache.jarPath("1.0.0")
+	require.NoError(t, os.MkdirAll(filepath.Dir(jarPath), 0755))
+	require.NoError(t, os.WriteFile(jarPath, []byte("tampered"), 0644))
+	downloader := givenMockFileDownloader()
+
+	// When
+	tool, err := NewCached("1.0.0", jarChecksum, cache, downloader, GithubReleaseBaseURL)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, jarPath, tool.Path())
+	downloader.AssertNumberOfCalls(t, "GetWithFallback", 1)
+}
+
+func Test_NewCached_EvictsEntryNotMatchingPinnedChecksum(t *testing.T) {
+	// Given
+	knownChecksums["1.0.0"] = jarChecksum
+	defer delete(knownChecksums, "1.0.0")
+	cache := NewCache(t.TempDir())
+	jarPath := cache.jarPath("1.0.0")
+	require.NoError(t, os.MkdirAll(filepath.Dir(jarPath), 0755))
+	require.NoError(t, os.WriteFile(jarPath, []byte("tampered"), 0644))
+	// The recorded checksum matches the tampered jar, only the pinned one tells it apart.
+	tamperedChecksum, err := fileChecksum(jarPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(jarPath+checksumFileExtension, []byte(tamperedChecksum+"\n"), 0644))
+	downloader := givenMockFileDownloader()
+
+	// When
+	tool, err := NewCached("1.0.0", "", cache, downloader, GithubReleaseBaseURL)
+
+	// Then
+	require.NoError(t, err)
+	content, err := os.ReadFile(tool.Path())
+	require.NoError(t, err)
+	require.Equal(t, jarContent, string(content))
+	downloader.AssertNumberOfCalls(t, "GetWithFallback", 1)
+}
+
+func Test_knownChecksums(t *testing.T) {
+	for version, checksum := range knownChecksums {
+		_, err := parseSemver(version)
+		require.NoError(t, err, version)
+		require.Regexp(t, "^[0-9a-f]{64}$", checksum, version)
+	}
+}
+
+func Test_NewCached_ChecksumMismatch(t *testing.T) {
+	// Given
+	cache := NewCache(t.TempDir())
+	downloader := givenMockFileDownloader()
+
+	// When
+	tool, err := NewCached("1.0.0", "sha256:0000", cache, downloader, GithubReleaseBaseURL)
+
+	// Then
+	require.EqualError(t, err, "downloaded bundletool 1.0.0 checksum mismatch, expected: 0000, actual: "+jarChecksum)
+	require.Nil(t, tool)
+	_, statErr := os.Stat(cache.jarPath("1.0.0"))
+	require.True(t, os.IsNotExist(statErr))
+}
+
+func Test_expectedChecksum(t *testing.T) {
+	knownChecksums["9.9.9"] = "pinned"
+	defer delete(knownChecksums, "9.9.9")
+
+	require.Equal(t, "pinned", expectedChecksum("9.9.9", ""))
+	require.Equal(t, "abcd", expectedChecksum("9.9.9", " SHA256:ABCD "))
+	require.Equal(t, "", expectedChecksum("1.0.0", ""))
+}
+
+func givenMockFileDownloader() *MockFileDownloader {
+	mockedFileDownloader := new(MockFileDownloader)
+	mockedFileDownloader.On("GetWithFallback", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	return mockedFileDownloader
+}
+
+func Test_NewCached_RequiredChecksum(t *testing.T) {
+	// Given
+	cache := NewCache(t.TempDir()).WithRequiredChecksum()
+	downloader := givenMockFileDownloader()
+
+	// When
+	tool, err := NewCached("1.0.0", "", cache, downloader, GithubReleaseBaseURL)
+
+	// Then
+	require.EqualError(t, err, "no checksum is known for bundletool 1.0.0, the downloaded jar's SHA-256 is: "+jarChecksum)
+	require.Nil(t, tool)
+	_, statErr := os.Stat(cache.jarPath("1.0.0"))
+	require.True(t, os.IsNotExist(statErr))
+}
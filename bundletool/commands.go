@@ -0,0 +1,298 @@
+package bundletool
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+)
+
+// VersionCommand returns the command printing the bundletool version.
+func (tool Tool) VersionCommand() *command.Model {
+	return tool.BuildCommand("version")
+}
+
+// ParseVersion parses the output of the version command.
+func ParseVersion(out string) (string, error) {
+	version := strings.TrimSpace(out)
+	if _, err := parseSemver(version); err != nil {
+		return "", fmt.Errorf("failed to parse bundletool version from: %s", out)
+	}
+	return version, nil
+}
+
+// Validate returns the command validating an .aab file.
+func (tool Tool) Validate(aabPath string) *command.Model {
+	return tool.BuildCommand("validate", "--bundle", aabPath)
+}
+
+// ValidateResult is the bundle information printed by the validate command.
+type ValidateResult struct {
+	Modules []string
+}
+
+var featureModulePattern = regexp.MustCompile(`^\s*Feature module: (.+)$`)
+
+// ParseValidate parses the output of the validate command.
+func ParseValidate(out string) ValidateResult {
+	var result ValidateResult
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		if match := featureModulePattern.FindStringSubmatch(scanner.Text()); match != nil {
+			result.Modules = append(result.Modules, strings.TrimSpace(match[1]))
+		}
+	}
+	return result
+}
+
+// DumpManifestOptions represents the optional parameters of the dump manifest command.
+type DumpManifestOptions struct {
+	// Module is the module to dump the manifest of, base if empty.
+	Module string
+	// XPath selects a part of the manifest, for example: /manifest/@android:versionCode.
+	XPath string
+}
+
+// DumpManifest returns the command printing the AndroidManifest.xml of an .aab file.
+func (tool Tool) DumpManifest(aabPath string, opts DumpManifestOptions) *command.Model {
+	args := []string{"manifest", "--bundle", aabPath}
+	if opts.Module != "" {
+		args = append(args, "--module", opts.Module)
+	}
+	if opts.XPath != "" {
+		args = append(args, "--xpath", opts.XPath)
+	}
+	return tool.BuildCommand("dump", args...)
+}
+
+// Manifest holds the app identifiers and SDK requirements of an AndroidManifest.xml.
+type Manifest struct {
+	Package          string
+	VersionCode      string
+	VersionName      string
+	MinSDKVersion    string
+	TargetSDKVersion string
+}
+
+type manifestXML struct {
+	Package     string `xml:"package,attr"`
+	VersionCode string `xml:"http://schemas.android.com/apk/res/android versionCode,attr"`
+	VersionName string `xml:"http://schemas.android.com/apk/res/android versionName,attr"`
+	UsesSDK     struct {
+		MinSDKVersion    string `xml:"http://schemas.android.com/apk/res/android minSdkVersion,attr"`
+		TargetSDKVersion string `xml:"http://schemas.android.com/apk/res/android targetSdkVersion,attr"`
+	} `xml:"uses-sdk"`
+}
+
+// ParseManifest parses the output of the dump manifest command, called without XPath.
+func ParseManifest(out string) (Manifest, error) {
+	var manifest manifestXML
+	if err := xml.Unmarshal([]byte(out), &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	return Manifest{
+		Package:          manifest.Package,
+		VersionCode:      manifest.VersionCode,
+		VersionName:      manifest.VersionName,
+		MinSDKVersion:    manifest.UsesSDK.MinSDKVersion,
+		TargetSDKVersion: manifest.UsesSDK.TargetSDKVersion,
+	}, nil
+}
+
+// DumpConfig returns the command printing the BundleConfig of an .aab file.
+func (tool Tool) DumpConfig(aabPath string) *command.Model {
+	return tool.BuildCommand("dump", "config", "--bundle", aabPath)
+}
+
+// BundleConfig is the build configuration of an .aab file.
+type BundleConfig struct {
+	Bundletool struct {
+		Version string `json:"version"`
+	} `json:"bundletool"`
+	Optimizations struct {
+		SplitsConfig struct {
+			SplitDimension []SplitDimension `json:"splitDimension"`
+		} `json:"splitsConfig"`
+	} `json:"optimizations"`
+	Compression struct {
+		UncompressedGlob []string `json:"uncompressedGlob"`
+	} `json:"compression"`
+}
+
+// SplitDimension is a dimension the APKs are split by, unless negated.
+type SplitDimension struct {
+	Value  string `json:"value"`
+	Negate bool   `json:"negate"`
+}
+
+// ParseConfig parses the output of the dump config command.
+func ParseConfig(out string) (BundleConfig, error) {
+	var config BundleConfig
+	if err := json.Unmarshal([]byte(out), &config); err != nil {
+		return BundleConfig{}, fmt.Errorf("failed to parse bundle config: %w", err)
+	}
+	return config, nil
+}
+
+// DumpResourcesOptions represents the optional parameters of the dump resources command.
+type DumpResourcesOptions struct {
+	// Resource limits the output to a resource, by ID (0x7f0e013a) or name (drawable/icon).
+	Resource string
+	// Values prints the resource values too.
+	Values bool
+}
+
+// DumpResources returns the command printing the resource table of an .aab file.
+func (tool Tool) DumpResources(aabPath string, opts DumpResourcesOptions) *command.Model {
+	args := []string{"resources", "--bundle", aabPath}
+	if opts.Resource != "" {
+		args = append(args, "--resource", opts.Resource)
+	}
+	if opts.Values {
+		args = append(args, "--values")
+	}
+	return tool.BuildCommand("dump", args...)
+}
+
+// Resource is an entry of the resource table.
+type Resource struct {
+	Package string
+	ID      string
+	Type    string
+	Name    string
+	Values  []ResourceValue
+}
+
+// ResourceValue is a resource's value for a configuration.
+type ResourceValue struct {
+	Config string
+	Value  string
+}
+
+var (
+	resourcePackagePattern = regexp.MustCompile(`^Package '(.+)':$`)
+	resourcePattern        = regexp.MustCompile(`^(0x[0-9a-fA-F]+) - ([^/]+)/(.+)$`)
+	resourceValuePattern   = regexp.MustCompile(`^\s+(.+?) - (.+)$`)
+)
+
+// ParseResources parses the output of the dump resources command.
+func ParseResources(out string) ([]Resource, error) {
+	var resources []Resource
+	pkg := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if match := resourcePackagePattern.FindStringSubmatch(line); match != nil {
+			pkg = match[1]
+			continue
+		}
+		if match := resourcePattern.FindStringSubmatch(line); match != nil {
+			resources = append(resources, Resource{Package: pkg, ID: match[1], Type: match[2], Name: match[3]})
+			continue
+		}
+		if match := resourceValuePattern.FindStringSubmatch(line); match != nil && len(resources) > 0 {
+			last := &resources[len(resources)-1]
+			last.Values = append(last.Values, ResourceValue{Config: match[1], Value: match[2]})
+			continue
+		}
+
+		return nil, fmt.Errorf("failed to parse resources line: %s", line)
+	}
+	return resources, scanner.Err()
+}
+
+// GetSizeOptions represents the optional parameters of the get-size total command.
+type GetSizeOptions struct {
+	// DeviceSpecPath limits the calculation to a device configuration.
+	DeviceSpecPath string
+	// Dimensions breaks down the sizes by the given dimensions, for example: SDK, ABI, SCREEN_DENSITY, LANGUAGE or ALL.
+	Dimensions []string
+	// Modules limits the calculation to the given modules.
+	Modules []string
+	// Instant calculates the size of the instant APKs.
+	Instant bool
+}
+
+// GetSizeTotal returns the command estimating the download sizes of the APKs in an .apks file.
+func (tool Tool) GetSizeTotal(apksPath string, opts GetSizeOptions) *command.Model {
+	args := []string{"total", "--apks", apksPath}
+	if opts.DeviceSpecPath != "" {
+		args = append(args, "--device-spec", opts.DeviceSpecPath)
+	}
+	if len(opts.Dimensions) > 0 {
+		args = append(args, "--dimensions="+strings.Join(opts.Dimensions, ","))
+	}
+	if len(opts.Modules) > 0 {
+		args = append(args, "--modules="+strings.Join(opts.Modules, ","))
+	}
+	if opts.Instant {
+		args = append(args, "--instant")
+	}
+	return tool.BuildCommand("get-size", args...)
+}
+
+// SizeEntry is a row of the get-size total output: the minimum and maximum download size in bytes
+// of a configuration described by the requested dimensions.
+type SizeEntry struct {
+	Dimensions map[string]string
+	Min        int64
+	Max        int64
+}
+
+// ParseSizeTotal parses the CSV output of the get-size total command.
+func ParseSizeTotal(out string) ([]SizeEntry, error) {
+	records, err := csv.NewReader(strings.NewReader(strings.TrimSpace(out))).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse size: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("failed to parse size from: %s", out)
+	}
+
+	header := records[0]
+	minIdx, maxIdx := -1, -1
+	for i, column := range header {
+		switch column {
+		case "MIN":
+			minIdx = i
+		case "MAX":
+			maxIdx = i
+		}
+	}
+	if minIdx == -1 || maxIdx == -1 {
+		return nil, fmt.Errorf("failed to parse size, MIN and MAX columns are missing: %s", out)
+	}
+
+	var entries []SizeEntry
+	for _, record := range records[1:] {
+		entry := SizeEntry{Dimensions: map[string]string{}}
+		for i, value := range record {
+			switch i {
+			case minIdx:
+				if entry.Min, err = strconv.ParseInt(value, 10, 64); err != nil {
+					return nil, fmt.Errorf("failed to parse size: %s", value)
+				}
+			case maxIdx:
+				if entry.Max, err = strconv.ParseInt(value, 10, 64); err != nil {
+					return nil, fmt.Errorf("failed to parse size: %s", value)
+				}
+			default:
+				entry.Dimensions[header[i]] = value
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
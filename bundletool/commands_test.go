@@ -0,0 +1,200 @@
+package bundletool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_VersionCommand(t *testing.T) {
+	// Given
+	tool := givenTool()
+	expectedCommand := []string{"java", "-jar", tool.path, "version"}
+
+	// When
+	actualCommand := tool.VersionCommand().GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_ParseVersion(t *testing.T) {
+	version, err := ParseVersion("1.15.4\n")
+	require.NoError(t, err)
+	require.Equal(t, "1.15.4", version)
+
+	_, err = ParseVersion("Error: Unable to access jarfile")
+	require.Error(t, err)
+}
+
+func Test_Validate(t *testing.T) {
+	// Given
+	tool := givenTool()
+	expectedCommand := []string{"java", "-jar", tool.path, "validate", "--bundle", "/path/to/app.aab"}
+
+	// When
+	actualCommand := tool.Validate("/path/to/app.aab").GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_ParseValidate(t *testing.T) {
+	// Given
+	out := `App Bundle information
+------------
+Feature modules:
+	Feature module: base
+		File: res/layout/activity_main.xml
+	Feature module: dynamic_feature
+		File: dex/classes.dex`
+
+	// When
+	result := ParseValidate(out)
+
+	// Then
+	require.Equal(t, ValidateResult{Modules: []string{"base", "dynamic_feature"}}, result)
+}
+
+func Test_DumpManifest(t *testing.T) {
+	// Given
+	tool := givenTool()
+	expectedCommand := []string{"java", "-jar", tool.path, "dump", "manifest", "--bundle", "/path/to/app.aab",
+		"--module", "feature", "--xpath", "/manifest/@android:versionCode"}
+
+	// When
+	actualCommand := tool.DumpManifest("/path/to/app.aab", DumpManifestOptions{Module: "feature", XPath: "/manifest/@android:versionCode"}).GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_ParseManifest(t *testing.T) {
+	// Given
+	out := `<manifest xmlns:android="http://schemas.android.com/apk/res/android" android:versionCode="4512" android:versionName="2.3.1" package="com.example.app">
+  <uses-sdk android:minSdkVersion="21" android:targetSdkVersion="33"/>
+  <application android:label="@string/app_name"/>
+</manifest>`
+
+	// When
+	manifest, err := ParseManifest(out)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, Manifest{
+		Package:          "com.example.app",
+		VersionCode:      "4512",
+		VersionName:      "2.3.1",
+		MinSDKVersion:    "21",
+		TargetSDKVersion: "33",
+	}, manifest)
+}
+
+func Test_DumpConfig(t *testing.T) {
+	// Given
+	tool := givenTool()
+	expectedCommand := []string{"java", "-jar", tool.path, "dump", "config", "--bundle", "/path/to/app.aab"}
+
+	// When
+	actualCommand := tool.DumpConfig("/path/to/app.aab").GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_ParseConfig(t *testing.T) {
+	// Given
+	out := `{
+  "bundletool": {"version": "1.15.4"},
+  "optimizations": {"splitsConfig": {"splitDimension": [{"value": "ABI"}, {"value": "LANGUAGE", "negate": true}]}},
+  "compression": {"uncompressedGlob": ["res/raw/**"]}
+}`
+
+	// When
+	config, err := ParseConfig(out)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "1.15.4", config.Bundletool.Version)
+	require.Equal(t, []SplitDimension{{Value: "ABI"}, {Value: "LANGUAGE", Negate: true}}, config.Optimizations.SplitsConfig.SplitDimension)
+	require.Equal(t, []string{"res/raw/**"}, config.Compression.UncompressedGlob)
+}
+
+func Test_DumpResources(t *testing.T) {
+	// Given
+	tool := givenTool()
+	expectedCommand := []string{"java", "-jar", tool.path, "dump", "resources", "--bundle", "/path/to/app.aab",
+		"--resource", "string/app_name", "--values"}
+
+	// When
+	actualCommand := tool.DumpResources("/path/to/app.aab", DumpResourcesOptions{Resource: "string/app_name", Values: true}).GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_ParseResources(t *testing.T) {
+	// Given
+	out := `Package 'com.example.app':
+0x7f010000 - anim/abc_fade_in
+	(default) - [FILE] res/anim/abc_fade_in.xml
+0x7f0e0000 - string/app_name
+	(default) - [STR] "My App"
+	locale: "fr" - [STR] "Mon App"
+`
+
+	// When
+	resources, err := ParseResources(out)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []Resource{
+		{
+			Package: "com.example.app", ID: "0x7f010000", Type: "anim", Name: "abc_fade_in",
+			Values: []ResourceValue{{Config: "(default)", Value: "[FILE] res/anim/abc_fade_in.xml"}},
+		},
+		{
+			Package: "com.example.app", ID: "0x7f0e0000", Type: "string", Name: "app_name",
+			Values: []ResourceValue{
+				{Config: "(default)", Value: `[STR] "My App"`},
+				{Config: `locale: "fr"`, Value: `[STR] "Mon App"`},
+			},
+		},
+	}, resources)
+}
+
+func Test_GetSizeTotal(t *testing.T) {
+	// Given
+	tool := givenTool()
+	opts := GetSizeOptions{DeviceSpecPath: "/path/to/device.json", Dimensions: []string{"SDK", "ABI"}, Modules: []string{"base"}, Instant: true}
+	expectedCommand := []string{"java", "-jar", tool.path, "get-size", "total", "--apks", "/path/to/app.apks",
+		"--device-spec", "/path/to/device.json", "--dimensions=SDK,ABI", "--modules=base", "--instant"}
+
+	// When
+	actualCommand := tool.GetSizeTotal("/path/to/app.apks", opts).GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_ParseSizeTotal(t *testing.T) {
+	// Given
+	out := `SDK,ABI,MIN,MAX
+21-,arm64-v8a,1048576,2097152
+21-,x86_64,1153433,2202009`
+
+	// When
+	entries, err := ParseSizeTotal(out)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []SizeEntry{
+		{Dimensions: map[string]string{"SDK": "21-", "ABI": "arm64-v8a"}, Min: 1048576, Max: 2097152},
+		{Dimensions: map[string]string{"SDK": "21-", "ABI": "x86_64"}, Min: 1153433, Max: 2202009},
+	}, entries)
+}
+
+func Test_ParseSizeTotal_Invalid(t *testing.T) {
+	_, err := ParseSizeTotal("MIN\n12")
+	require.Error(t, err)
+}
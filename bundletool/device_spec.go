@@ -0,0 +1,48 @@
+package bundletool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/bitrise-io/go-utils/command"
+)
+
+// DeviceSpec describes a device configuration, in the format of bundletool's get-device-spec output.
+type DeviceSpec struct {
+	SupportedABIs    []string `json:"supportedAbis"`
+	SupportedLocales []string `json:"supportedLocales"`
+	ScreenDensity    int      `json:"screenDensity"`
+	SDKVersion       int      `json:"sdkVersion"`
+}
+
+// ReadDeviceSpec reads and validates a device spec JSON file.
+func ReadDeviceSpec(pth string) (DeviceSpec, error) {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return DeviceSpec{}, err
+	}
+
+	var spec DeviceSpec
+	if err := json.Unmarshal(content, &spec); err != nil {
+		return DeviceSpec{}, fmt.Errorf("failed to parse device spec (%s): %w", pth, err)
+	}
+
+	if spec.SDKVersion <= 0 {
+		return DeviceSpec{}, fmt.Errorf("invalid device spec (%s): sdkVersion is required", pth)
+	}
+	if len(spec.SupportedABIs) == 0 {
+		return DeviceSpec{}, fmt.Errorf("invalid device spec (%s): supportedAbis is required", pth)
+	}
+	return spec, nil
+}
+
+// ExtractAPKs extracts the APKs matching the given device spec from an .apks file, into the output directory.
+func (tool Tool) ExtractAPKs(apksPath, outputDir, deviceSpecPath string) *command.Model {
+	args := []string{}
+	args = append(args, "--apks", apksPath)
+	args = append(args, "--output-dir", outputDir)
+	args = append(args, "--device-spec", deviceSpecPath)
+
+	return tool.BuildCommand("extract-apks", args...)
+}
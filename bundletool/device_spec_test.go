@@ -0,0 +1,63 @@
+package bundletool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExtractAPKs(t *testing.T) {
+	// Given
+	tool := givenTool()
+	expectedCommand := []string{"java", "-jar", tool.path, "extract-apks", "--apks", "/path/to/app.apks",
+		"--output-dir", "/path/to/out", "--device-spec", "/path/to/device.json"}
+
+	// When
+	actualCommand := tool.ExtractAPKs("/path/to/app.apks", "/path/to/out", "/path/to/device.json").GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_ReadDeviceSpec(t *testing.T) {
+	// Given
+	pth := givenDeviceSpec(t, `{"supportedAbis": ["arm64-v8a", "armeabi-v7a"], "supportedLocales": ["en-US"], "screenDensity": 640, "sdkVersion": 27}`)
+
+	// When
+	spec, err := ReadDeviceSpec(pth)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, DeviceSpec{
+		SupportedABIs:    []string{"arm64-v8a", "armeabi-v7a"},
+		SupportedLocales: []string{"en-US"},
+		ScreenDensity:    640,
+		SDKVersion:       27,
+	}, spec)
+}
+
+func Test_ReadDeviceSpec_Invalid(t *testing.T) {
+	scenarios := []struct {
+		content     string
+		expectedErr string
+	}{
+		{content: `{"supportedAbis": ["x86"]}`, expectedErr: "sdkVersion is required"},
+		{content: `{"sdkVersion": 27}`, expectedErr: "supportedAbis is required"},
+		{content: `not json`, expectedErr: "failed to parse device spec"},
+	}
+
+	for _, scenario := range scenarios {
+		_, err := ReadDeviceSpec(givenDeviceSpec(t, scenario.content))
+
+		require.Error(t, err)
+		require.Contains(t, err.Error(), scenario.expectedErr)
+	}
+}
+
+func givenDeviceSpec(t *testing.T, content string) string {
+	pth := filepath.Join(t.TempDir(), "device-spec.json")
+	require.NoError(t, os.WriteFile(pth, []byte(content), 0644))
+	return pth
+}
@@ -0,0 +1,182 @@
+package bundletool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const defaultMinimumJavaVersion = 8
+
+// minimumJavaVersions lists the bundletool versions raising the required Java version,
+// based on: https://github.com/google/bundletool/releases
+// A preinstalled bundletool is also run with the Java runtime, catching versions missing from the list, see Tool.runVersion.
+var minimumJavaVersions = []struct {
+	since string
+	java  int
+}{
+	{since: "1.17.0", java: 11},
+}
+
+var javaVersionPattern = regexp.MustCompile(`version "([^"]+)"`)
+
+// unsupportedClassVersionError is thrown by the JVM if a class was compiled for a newer Java version.
+const unsupportedClassVersionError = "java.lang.UnsupportedClassVersionError"
+
+var errUnsupportedClassVersion = errors.New("unsupported class version")
+
+// JavaRuntime describes the Java runtime used to run bundletool.
+type JavaRuntime struct {
+	Path         string
+	MajorVersion int
+}
+
+// FindJava looks up the Java runtime in JAVA_HOME, then on the PATH and determines its version.
+func FindJava() (JavaRuntime, error) {
+	javaPath := ""
+	if javaHome := os.Getenv("JAVA_HOME"); javaHome != "" {
+		pth := filepath.Join(javaHome, "bin", "java")
+		if _, err := os.Stat(pth); err != nil {
+			return JavaRuntime{}, fmt.Errorf("JAVA_HOME is set to %s, but %s does not exist", javaHome, pth)
+		}
+		javaPath = pth
+	} else {
+		pth, err := exec.LookPath("java")
+		if err != nil {
+			return JavaRuntime{}, fmt.Errorf("java not found: JAVA_HOME is not set and java is not on the PATH")
+		}
+		javaPath = pth
+	}
+
+	cmd := command.New(javaPath, "-version")
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return JavaRuntime{}, fmt.Errorf("%s failed: %s: %w", cmd.PrintableCommandArgs(), out, err)
+	}
+
+	version, err := parseJavaVersion(out)
+	if err != nil {
+		return JavaRuntime{}, err
+	}
+	return JavaRuntime{Path: javaPath, MajorVersion: version}, nil
+}
+
+// parseJavaVersion returns the major version from the `java -version` output.
+// Sample outputs: `openjdk version "17.0.2" 2022-01-18`, `java version "1.8.0_292"`.
+func parseJavaVersion(out string) (int, error) {
+	match := javaVersionPattern.FindStringSubmatch(out)
+	if match == nil {
+		return 0, fmt.Errorf("failed to parse java version from: %s", out)
+	}
+
+	version := strings.TrimPrefix(match[1], "1.")
+	major := strings.FieldsFunc(version, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if len(major) == 0 {
+		return 0, fmt.Errorf("failed to parse java version from: %s", out)
+	}
+	return strconv.Atoi(major[0])
+}
+
+// MinimumJavaVersion returns the minimum Java major version required by the given bundletool version.
+func MinimumJavaVersion(bundletoolVersion string) int {
+	minimum := defaultMinimumJavaVersion
+	v, err := parseSemver(bundletoolVersion)
+	if err != nil {
+		return minimum
+	}
+
+	for _, requirement := range minimumJavaVersions {
+		since, err := parseSemver(requirement.since)
+		if err != nil {
+			continue
+		}
+		if v.compare(since) >= 0 {
+			minimum = requirement.java
+		}
+	}
+	return minimum
+}
+
+// CheckJava verifies that the Java runtime can run the tool's bundletool jar,
+// and returns a Tool invoking bundletool with the given runtime.
+// If the version is unknown (preinstalled bundletool), it is determined by running `bundletool version`.
+// Launchers select their own Java runtime, see CheckLauncher.
+func (tool Tool) CheckJava(java JavaRuntime) (Tool, error) {
+	if tool.launcher {
+		return tool.CheckLauncher()
+	}
+	tool.java = java.Path
+
+	if tool.version == "" {
+		version, err := tool.runVersion()
+		if errors.Is(err, errUnsupportedClassVersion) {
+			return Tool{}, fmt.Errorf("bundletool at %s can not run on Java %d found at %s: "+
+				"set JAVA_HOME to a newer Java installation (for example with the Set Java version Step), or select an older bundletool version",
+				tool.path, java.MajorVersion, java.Path)
+		}
+		if err != nil {
+			log.Warnf("Failed to determine the bundletool version, skipping the Java version check: %s", err)
+			return tool, nil
+		}
+		log.Infof("Preinstalled bundletool version: %s", version)
+		tool.version = version
+	}
+
+	if minimum := MinimumJavaVersion(tool.version); java.MajorVersion < minimum {
+		return Tool{}, fmt.Errorf("bundletool %s requires Java %d or newer, but Java %d was found at %s: "+
+			"set JAVA_HOME to a Java %d+ installation (for example with the Set Java version Step), or select an older bundletool version",
+			tool.version, minimum, java.MajorVersion, java.Path, minimum)
+	}
+
+	return tool, nil
+}
+
+// CheckLauncher runs `bundletool version` with the launcher, which selects its own Java runtime (usually from JAVA_HOME),
+// and returns the Tool with the reported version. No Java runtime has to be found for a launcher.
+func (tool Tool) CheckLauncher() (Tool, error) {
+	version, err := tool.runVersion()
+	if errors.Is(err, errUnsupportedClassVersion) {
+		return Tool{}, fmt.Errorf("bundletool launcher %s runs on a too old Java runtime: "+
+			"set JAVA_HOME to a newer Java installation (for example with the Set Java version Step), or use a bundletool jar", tool.path)
+	}
+	if err != nil {
+		log.Warnf("Failed to determine the bundletool version, skipping the Java version check: %s", err)
+		return tool, nil
+	}
+
+	log.Infof("Preinstalled bundletool version: %s", version)
+	log.Printf("bundletool launcher %s selects its own Java runtime", tool.path)
+	tool.version = version
+	return tool, nil
+}
+
+// runVersion returns the version printed by `bundletool version`.
+// errUnsupportedClassVersion is returned if the Java runtime is older than the one bundletool was compiled for.
+func (tool Tool) runVersion() (string, error) {
+	cmd := tool.BuildCommand("version")
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		if strings.Contains(out, unsupportedClassVersionError) {
+			return "", errUnsupportedClassVersion
+		}
+		return "", fmt.Errorf("%s failed: %s: %w", cmd.PrintableCommandArgs(), out, err)
+	}
+
+	// The JVM may print notices (for example: Picked up JAVA_TOOL_OPTIONS) before the version.
+	lines := strings.Split(out, "\n")
+	version := strings.TrimSpace(lines[len(lines)-1])
+	if _, err := parseSemver(version); err != nil {
+		return "", fmt.Errorf("unexpected %s output: %s", cmd.PrintableCommandArgs(), out)
+	}
+	return version, nil
+}
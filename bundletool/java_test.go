@@ -0,0 +1,165 @@
+package bundletool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseJavaVersion(t *testing.T) {
+	scenarios := []struct {
+		output   string
+		expected int
+	}{
+		{output: "java version \"1.8.0_292\"\nJava(TM) SE Runtime Environment (build 1.8.0_292-b10)", expected: 8},
+		{output: "openjdk version \"11.0.12\" 2021-07-20\nOpenJDK Runtime Environment", expected: 11},
+		{output: "openjdk version \"17.0.2\" 2022-01-18", expected: 17},
+		{output: "openjdk version \"21\" 2023-09-19", expected: 21},
+		{output: "openjdk version \"9-ea\"", expected: 9},
+	}
+
+	for _, scenario := range scenarios {
+		actual, err := parseJavaVersion(scenario.output)
+
+		require.NoError(t, err)
+		require.Equal(t, scenario.expected, actual)
+	}
+}
+
+func Test_parseJavaVersion_Invalid(t *testing.T) {
+	_, err := parseJavaVersion("command not found")
+
+	require.EqualError(t, err, "failed to parse java version from: command not found")
+}
+
+func Test_MinimumJavaVersion(t *testing.T) {
+	require.Equal(t, 8, MinimumJavaVersion("1.8.1"))
+	require.Equal(t, 11, MinimumJavaVersion("1.17.0"))
+	require.Equal(t, 11, MinimumJavaVersion("2.0.0"))
+	require.Equal(t, 8, MinimumJavaVersion(""))
+}
+
+func Test_FindJava_JavaHome(t *testing.T) {
+	// Given
+	javaHome := givenJavaHome(t, "openjdk version \"17.0.2\" 2022-01-18")
+
+	// When
+	java, err := FindJava()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, JavaRuntime{Path: filepath.Join(javaHome, "bin", "java"), MajorVersion: 17}, java)
+}
+
+func Test_FindJava_MissingJavaHomeBinary(t *testing.T) {
+	// Given
+	javaHome := t.TempDir()
+	t.Setenv("JAVA_HOME", javaHome)
+
+	// When
+	_, err := FindJava()
+
+	// Then
+	require.EqualError(t, err, "JAVA_HOME is set to "+javaHome+", but "+filepath.Join(javaHome, "bin", "java")+" does not exist")
+}
+
+func Test_CheckJava(t *testing.T) {
+	// Given
+	tool := Tool{path: "/path/to/bundletool.jar", version: "1.17.0"}
+	java := JavaRuntime{Path: "/jdk/bin/java", MajorVersion: 17}
+
+	// When
+	checkedTool, err := tool.CheckJava(java)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"/jdk/bin/java", "-jar", "/path/to/bundletool.jar", "version"}, checkedTool.BuildCommand("version").GetCmd().Args)
+}
+
+func Test_CheckJava_Incompatible(t *testing.T) {
+	// Given
+	tool := Tool{path: "/path/to/bundletool.jar", version: "1.17.0"}
+	java := JavaRuntime{Path: "/jdk/bin/java", MajorVersion: 8}
+
+	// When
+	_, err := tool.CheckJava(java)
+
+	// Then
+	require.EqualError(t, err, "bundletool 1.17.0 requires Java 11 or newer, but Java 8 was found at /jdk/bin/java: "+
+		"set JAVA_HOME to a Java 11+ installation (for example with the Set Java version Step), or select an older bundletool version")
+}
+
+func Test_CheckJava_UnknownVersion(t *testing.T) {
+	// Given
+	tool := Tool{path: "/path/to/bundletool.jar"}
+	java := JavaRuntime{Path: givenScript(t, "java", "echo 'Picked up JAVA_TOOL_OPTIONS: -Xmx2g' >&2\necho 1.17.0"), MajorVersion: 8}
+
+	// When
+	_, err := tool.CheckJava(java)
+
+	// Then
+	require.EqualError(t, err, "bundletool 1.17.0 requires Java 11 or newer, but Java 8 was found at "+java.Path+": "+
+		"set JAVA_HOME to a Java 11+ installation (for example with the Set Java version Step), or select an older bundletool version")
+}
+
+func Test_CheckJava_UnsupportedClassVersion(t *testing.T) {
+	// Given
+	tool := Tool{path: "/path/to/bundletool.jar"}
+	output := "Error: LinkageError occurred while loading main class com.android.tools.build.bundletool.BundleToolMain\n" +
+		"java.lang.UnsupportedClassVersionError: compiled by a more recent version of the Java Runtime (class file version 55.0)"
+	java := JavaRuntime{Path: givenScript(t, "java", "echo '"+output+"' >&2\nexit 1"), MajorVersion: 8}
+
+	// When
+	_, err := tool.CheckJava(java)
+
+	// Then
+	require.EqualError(t, err, "bundletool at /path/to/bundletool.jar can not run on Java 8 found at "+java.Path+": "+
+		"set JAVA_HOME to a newer Java installation (for example with the Set Java version Step), or select an older bundletool version")
+}
+
+func Test_CheckJava_VersionCheckSkipped(t *testing.T) {
+	// Given
+	tool := Tool{path: "/path/to/bundletool.jar"}
+	java := JavaRuntime{Path: givenScript(t, "java", "echo 'Unable to access jarfile' >&2\nexit 1"), MajorVersion: 8}
+
+	// When
+	checkedTool, err := tool.CheckJava(java)
+
+	// Then
+	require.NoError(t, err)
+	require.Empty(t, checkedTool.Version())
+	require.Equal(t, java.Path, checkedTool.BuildCommand("version").GetCmd().Args[0])
+}
+
+func Test_CheckLauncher(t *testing.T) {
+	// Given
+	t.Setenv("PATH", "")
+	t.Setenv("JAVA_HOME", "")
+	tool := Tool{path: givenScript(t, "bundletool", "echo 1.18.1"), launcher: true}
+
+	// When
+	checkedTool, err := tool.CheckLauncher()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "1.18.1", checkedTool.Version())
+	require.Equal(t, []string{tool.path, "version"}, checkedTool.BuildCommand("version").GetCmd().Args)
+}
+
+func givenScript(t *testing.T, name, body string) string {
+	pth := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(pth, []byte("#!/bin/sh\n"+body+"\n"), 0755))
+	return pth
+}
+
+func givenJavaHome(t *testing.T, versionOutput string) string {
+	javaHome := t.TempDir()
+	binDir := filepath.Join(javaHome, "bin")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	script := "#!/bin/sh\necho '" + versionOutput + "' >&2\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "java"), []byte(script), 0755))
+	t.Setenv("JAVA_HOME", javaHome)
+	return javaHome
+}
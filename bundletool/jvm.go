@@ -0,0 +1,108 @@
+package bundletool
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	javaToolOptionsEnvKey = "JAVA_TOOL_OPTIONS"
+	// outOfMemoryRAMPercentage is the heap limit used when retrying without an explicit maximum heap size.
+	outOfMemoryRAMPercentage = 75
+)
+
+// JVMOptions configures the Java virtual machine running bundletool.
+type JVMOptions struct {
+	// MaxHeap is the maximum heap size passed as -Xmx, for example: 4g.
+	MaxHeap string
+	// TmpDir is the temporary directory passed as -Djava.io.tmpdir.
+	TmpDir string
+	// Args are arbitrary extra JVM arguments.
+	Args []string
+}
+
+// args returns the JVM arguments described by the options.
+func (opts JVMOptions) args() []string {
+	var args []string
+	if opts.MaxHeap != "" {
+		args = append(args, "-Xmx"+opts.MaxHeap)
+	}
+	if opts.TmpDir != "" {
+		args = append(args, "-Djava.io.tmpdir="+opts.TmpDir)
+	}
+	return append(args, opts.Args...)
+}
+
+// WithJVMOptions returns a copy of the tool running bundletool with the given JVM options.
+func (tool Tool) WithJVMOptions(opts JVMOptions) Tool {
+	tool.jvmOptions = opts
+	return tool
+}
+
+// WithLargerHeap returns a copy of the tool running bundletool with a doubled maximum heap size.
+// If no maximum heap size was set, the JVM may use 75% of the physical memory.
+// A maximum heap size in the extra arguments overrides MaxHeap (the JVM uses the last one),
+// so it is removed from the arguments and doubled instead.
+func (tool Tool) WithLargerHeap() Tool {
+	opts := tool.jvmOptions
+	opts.Args = []string{}
+	for _, arg := range tool.jvmOptions.Args {
+		if size, ok := maxHeapArg(arg); ok {
+			opts.MaxHeap = size
+			continue
+		}
+		opts.Args = append(opts.Args, arg)
+	}
+
+	if doubled, err := doubleHeapSize(opts.MaxHeap); err == nil {
+		opts.MaxHeap = doubled
+	} else {
+		opts.MaxHeap = ""
+		opts.Args = append(opts.Args, fmt.Sprintf("-XX:MaxRAMPercentage=%d", outOfMemoryRAMPercentage))
+	}
+
+	tool.jvmOptions = opts
+	return tool
+}
+
+// maxHeapArg returns the size set by a maximum heap size JVM argument, like -Xmx4g or -XX:MaxHeapSize=4g.
+func maxHeapArg(arg string) (string, bool) {
+	for _, prefix := range []string{"-Xmx", "-XX:MaxHeapSize="} {
+		if strings.HasPrefix(arg, prefix) {
+			return strings.TrimPrefix(arg, prefix), true
+		}
+	}
+	return "", false
+}
+
+// doubleHeapSize doubles a JVM memory size like 512m or 4g.
+func doubleHeapSize(size string) (string, error) {
+	size = strings.TrimSpace(size)
+	if size == "" {
+		return "", fmt.Errorf("heap size not set")
+	}
+
+	unit := ""
+	if last := size[len(size)-1:]; strings.ContainsAny(last, "kKmMgGtT") {
+		unit = last
+		size = size[:len(size)-1]
+	}
+
+	n, err := strconv.ParseUint(size, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid heap size: %s%s", size, unit)
+	}
+	return strconv.FormatUint(2*n, 10) + unit, nil
+}
+
+// javaToolOptionsEnv returns the JAVA_TOOL_OPTIONS environment variable carrying the JVM options,
+// used to configure the JVM started by a bundletool launcher.
+func (opts JVMOptions) javaToolOptionsEnv() string {
+	options := opts.args()
+	if existing := os.Getenv(javaToolOptionsEnvKey); existing != "" {
+		options = append([]string{existing}, options...)
+	}
+	return javaToolOptionsEnvKey + "=" + strings.Join(options, " ")
+}
@@ -0,0 +1,69 @@
+package bundletool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BuildCommand_JVMOptions(t *testing.T) {
+	// Given
+	tool := givenTool().WithJVMOptions(JVMOptions{MaxHeap: "4g", TmpDir: "/big/tmp", Args: []string{"-XX:+UseG1GC"}})
+	expectedCommand := []string{"java", "-Xmx4g", "-Djava.io.tmpdir=/big/tmp", "-XX:+UseG1GC", "-jar", tool.path, "command", "arg1"}
+
+	// When
+	actualCommand := tool.BuildCommand("command", "arg1").GetCmd().Args
+
+	// Then
+	require.Equal(t, expectedCommand, actualCommand)
+}
+
+func Test_BuildCommand_LauncherJVMOptions(t *testing.T) {
+	// Given
+	t.Setenv(javaToolOptionsEnvKey, "-Dfile.encoding=UTF-8")
+	tool := Tool{path: "/usr/local/bin/bundletool", launcher: true}.WithJVMOptions(JVMOptions{MaxHeap: "4g"})
+
+	// When
+	cmd := tool.BuildCommand("command").GetCmd()
+
+	// Then
+	require.Equal(t, []string{"/usr/local/bin/bundletool", "command"}, cmd.Args)
+	require.Contains(t, cmd.Env, "JAVA_TOOL_OPTIONS=-Dfile.encoding=UTF-8 -Xmx4g")
+}
+
+func Test_WithLargerHeap(t *testing.T) {
+	scenarios := []struct {
+		opts     JVMOptions
+		expected JVMOptions
+	}{
+		{
+			opts:     JVMOptions{MaxHeap: "2g"},
+			expected: JVMOptions{MaxHeap: "4g", Args: []string{}},
+		},
+		{
+			opts:     JVMOptions{MaxHeap: "1536"},
+			expected: JVMOptions{MaxHeap: "3072", Args: []string{}},
+		},
+		{
+			opts:     JVMOptions{Args: []string{"-XX:+UseG1GC"}},
+			expected: JVMOptions{Args: []string{"-XX:+UseG1GC", "-XX:MaxRAMPercentage=75"}},
+		},
+		{
+			opts:     JVMOptions{MaxHeap: "2g", Args: []string{"-Xmx3g", "-XX:+UseG1GC"}},
+			expected: JVMOptions{MaxHeap: "6g", Args: []string{"-XX:+UseG1GC"}},
+		},
+		{
+			opts:     JVMOptions{Args: []string{"-XX:MaxHeapSize=1g"}},
+			expected: JVMOptions{MaxHeap: "2g", Args: []string{}},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		tool := givenTool().WithJVMOptions(scenario.opts)
+
+		actual := tool.WithLargerHeap()
+
+		require.Equal(t, scenario.expected, actual.jvmOptions)
+		require.Equal(t, scenario.opts, tool.jvmOptions)
+	}
+}
@@ -0,0 +1,175 @@
+package bundletool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Keys of the signing config in a Gradle keystore.properties file.
+const (
+	propertyStoreFile     = "storeFile"
+	propertyStorePassword = "storePassword"
+	propertyKeyAlias      = "keyAlias"
+	propertyKeyPassword   = "keyPassword"
+)
+
+// ReadKeystoreProperties reads the signing config of a Gradle keystore.properties file.
+// A relative storeFile is resolved against the file's directory, the Path is returned as a file:// URL.
+// Keys missing from the file are left empty.
+func ReadKeystoreProperties(pth string) (KeystoreConfig, error) {
+	absPth, err := filepath.Abs(pth)
+	if err != nil {
+		return KeystoreConfig{}, err
+	}
+	f, err := os.Open(absPth)
+	if err != nil {
+		return KeystoreConfig{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	properties, err := parseProperties(f)
+	if err != nil {
+		return KeystoreConfig{}, fmt.Errorf("failed to parse %s: %w", pth, err)
+	}
+
+	var config KeystoreConfig
+	if storeFile := properties[propertyStoreFile]; storeFile != "" {
+		if !filepath.IsAbs(storeFile) {
+			storeFile = filepath.Join(filepath.Dir(absPth), storeFile)
+		}
+		config.Path = "file://" + storeFile
+	}
+	if password, ok := properties[propertyStorePassword]; ok {
+		config.KeystorePassword = passwordPassPrefix + password
+	}
+	config.SigningKeyAlias = properties[propertyKeyAlias]
+	if password, ok := properties[propertyKeyPassword]; ok {
+		config.SigningKeyPassword = passwordPassPrefix + password
+	}
+	return config, nil
+}
+
+// parseProperties parses the Java .properties format: key=value, key:value or key value pairs,
+// # and ! comments, backslash line continuations and escape sequences.
+// The content is decoded as ISO 8859-1, like Properties.load does in a Gradle build script.
+func parseProperties(r io.Reader) (map[string]string, error) {
+	properties := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	var logical string
+	continued := false
+	for scanner.Scan() {
+		line := strings.TrimLeft(latin1(scanner.Bytes()), " \t\f")
+		if !continued && (line == "" || line[0] == '#' || line[0] == '!') {
+			continue
+		}
+		logical += line
+
+		// An odd number of trailing backslashes continues the line
+		trailing := len(logical) - len(strings.TrimRight(logical, `\`))
+		if continued = trailing%2 == 1; continued {
+			logical = logical[:len(logical)-1]
+			continue
+		}
+
+		key, value, err := splitProperty(logical)
+		if err != nil {
+			return nil, err
+		}
+		properties[key] = value
+		logical = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if continued {
+		key, value, err := splitProperty(logical)
+		if err != nil {
+			return nil, err
+		}
+		properties[key] = value
+	}
+	return properties, nil
+}
+
+// splitProperty splits a logical line at the first unescaped =, : or whitespace, and unescapes the key and the value.
+func splitProperty(line string) (string, string, error) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.ContainsRune("=: \t\f", rune(line[i])) {
+			end = i
+			break
+		}
+	}
+
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+
+	key, err := unescapeProperty(line[:end])
+	if err != nil {
+		return "", "", err
+	}
+	value, err := unescapeProperty(rest)
+	if err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func unescapeProperty(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\u escape: %s", s[i-1:])
+			}
+			code, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\u escape: %s", s[i-1:i+5])
+			}
+			b.WriteRune(rune(code))
+			i += 4
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,72 @@
+package bundletool
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadKeystoreProperties(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	pth := filepath.Join(dir, "keystore.properties")
+	content := `# Generated by the CI
+storeFile=../release.jks
+storePassword = store\:pass
+keyAlias: upload
+keyPassword key \
+    pass
+`
+	require.NoError(t, os.WriteFile(pth, []byte(content), 0600))
+
+	// When
+	config, err := ReadKeystoreProperties(pth)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, KeystoreConfig{
+		Path:               "file://" + filepath.Join(filepath.Dir(dir), "release.jks"),
+		KeystorePassword:   "pass:store:pass",
+		SigningKeyAlias:    "upload",
+		SigningKeyPassword: "pass:key pass",
+	}, config)
+}
+
+func TestReadKeystoreProperties_AbsoluteStoreFile(t *testing.T) {
+	// Given
+	pth := filepath.Join(t.TempDir(), "keystore.properties")
+	require.NoError(t, os.WriteFile(pth, []byte("storeFile=/keystores/release.jks\n"), 0600))
+
+	// When
+	config, err := ReadKeystoreProperties(pth)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, KeystoreConfig{Path: "file:///keystores/release.jks"}, config)
+}
+
+func Test_parseProperties(t *testing.T) {
+	// Given
+	content := "! comment\n" +
+		"empty=\n" +
+		"escaped\\ key=tab\\there\n" +
+		"unicode=\\u00e9t\\u00E9\n" +
+		"latin1=caf\xe9\n" +
+		"trailing=backslash\\\\\n"
+
+	// When
+	properties, err := parseProperties(strings.NewReader(content))
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"empty":       "",
+		"escaped key": "tab\there",
+		"unicode":     "été",
+		"latin1":      "café",
+		"trailing":    `backslash\`,
+	}, properties)
+}
@@ -0,0 +1,157 @@
+package bundletool
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Password argument forms accepted by bundletool's --ks-pass and --key-pass flags.
+const (
+	passwordPassPrefix = "pass:"
+	passwordFilePrefix = "file:"
+)
+
+// PasswordSourceKind tells where a password is read from.
+type PasswordSourceKind string
+
+// Password source kinds.
+const (
+	// PasswordLiteral is a password given as is.
+	PasswordLiteral PasswordSourceKind = "literal"
+	// PasswordFile is a password stored in the first line of a file.
+	PasswordFile PasswordSourceKind = "file"
+	// PasswordEnv is a password stored in an environment variable.
+	PasswordEnv PasswordSourceKind = "env"
+	// PasswordStdin is a password read as a line from the standard input.
+	PasswordStdin PasswordSourceKind = "stdin"
+	// PasswordLegacy is a password in bundletool's pass: or file: form, as earlier versions of the Step passed it to bundletool.
+	// Values without either prefix are literal passwords.
+	PasswordLegacy PasswordSourceKind = "legacy"
+)
+
+// PasswordSource describes a keystore or key password.
+type PasswordSource struct {
+	Kind PasswordSourceKind
+	// Value is the literal password, the password file's path or the environment variable's name, depending on the Kind.
+	Value string
+}
+
+// ParsePasswordSourceKind parses a password source kind, an empty kind means PasswordLiteral.
+func ParsePasswordSourceKind(kind string) (PasswordSourceKind, error) {
+	switch k := PasswordSourceKind(strings.TrimSpace(kind)); k {
+	case "":
+		return PasswordLiteral, nil
+	case PasswordLiteral, PasswordFile, PasswordEnv, PasswordStdin, PasswordLegacy:
+		return k, nil
+	default:
+		return "", fmt.Errorf("unknown password source: %s", kind)
+	}
+}
+
+// IsSet returns false if the source can not provide a password.
+// Stdin sources are always considered set, as they are only read when needed.
+func (source PasswordSource) IsSet() bool {
+	return source.Kind == PasswordStdin || source.Value != ""
+}
+
+// Read returns the password. Stdin sources read a line from stdin,
+// so the sources sharing the same reader consume the consecutive lines.
+func (source PasswordSource) Read(stdin *bufio.Reader) (string, error) {
+	switch source.Kind {
+	case PasswordLiteral, "":
+		return source.Value, nil
+	case PasswordFile:
+		return readPasswordFile(source.Value)
+	case PasswordEnv:
+		password, ok := os.LookupEnv(source.Value)
+		if !ok {
+			return "", fmt.Errorf("password environment variable (%s) is not set", source.Value)
+		}
+		return password, nil
+	case PasswordLegacy:
+		return PasswordSourceFromArg(source.Value).Read(stdin)
+	case PasswordStdin:
+		line, err := stdin.ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", fmt.Errorf("failed to read password from stdin: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	default:
+		return "", fmt.Errorf("unknown password source: %s", source.Kind)
+	}
+}
+
+// Arg returns the password in bundletool's file: form, keeping it off the command line.
+// Password files are passed as is, other passwords are written to a 0600 file named name in dir.
+func (source PasswordSource) Arg(stdin *bufio.Reader, dir, name string) (string, error) {
+	if source.Kind == PasswordLegacy {
+		return PasswordSourceFromArg(source.Value).Arg(stdin, dir, name)
+	}
+	if source.Kind == PasswordFile {
+		pth, err := filepath.Abs(source.Value)
+		if err != nil {
+			return "", err
+		}
+		return passwordFilePrefix + pth, nil
+	}
+
+	password, err := source.Read(stdin)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(password, "\r\n") {
+		return "", errors.New("password must not contain line breaks")
+	}
+
+	pth := filepath.Join(dir, name)
+	if err := os.WriteFile(pth, []byte(password), 0600); err != nil {
+		return "", err
+	}
+	return passwordFilePrefix + pth, nil
+}
+
+// PasswordFromArg returns the password of a bundletool pass: or file: password argument.
+func PasswordFromArg(arg string) (string, error) {
+	switch {
+	case strings.HasPrefix(arg, passwordPassPrefix):
+		return strings.TrimPrefix(arg, passwordPassPrefix), nil
+	case strings.HasPrefix(arg, passwordFilePrefix):
+		return readPasswordFile(strings.TrimPrefix(arg, passwordFilePrefix))
+	default:
+		return "", errors.New("password argument has to start with pass: or file:")
+	}
+}
+
+// PasswordSourceFromArg returns the source of a password given in bundletool's pass: or file: form.
+// Values without either prefix are literal passwords, so a password starting with pass: or file: has to be qualified,
+// for example pass:pass:word.
+func PasswordSourceFromArg(arg string) PasswordSource {
+	switch {
+	case strings.HasPrefix(arg, passwordPassPrefix):
+		return PasswordSource{Kind: PasswordLiteral, Value: strings.TrimPrefix(arg, passwordPassPrefix)}
+	case strings.HasPrefix(arg, passwordFilePrefix):
+		return PasswordSource{Kind: PasswordFile, Value: strings.TrimPrefix(arg, passwordFilePrefix)}
+	default:
+		return PasswordSource{Kind: PasswordLiteral, Value: arg}
+	}
+}
+
+// IsPasswordArg returns true if the value is in bundletool's pass: or file: password argument form.
+func IsPasswordArg(value string) bool {
+	return strings.HasPrefix(value, passwordPassPrefix) || strings.HasPrefix(value, passwordFilePrefix)
+}
+
+// readPasswordFile returns the first line of a password file, like bundletool.
+func readPasswordFile(pth string) (string, error) {
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %w", err)
+	}
+	line, _, _ := strings.Cut(string(content), "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
@@ -0,0 +1,100 @@
+package bundletool
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordSource_Arg_Literal(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	source := PasswordSource{Kind: PasswordLiteral, Value: "pass:word"}
+
+	// When
+	arg, err := source.Arg(nil, dir, "password")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "file:"+filepath.Join(dir, "password"), arg)
+	info, err := os.Stat(filepath.Join(dir, "password"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	password, err := PasswordFromArg(arg)
+	require.NoError(t, err)
+	require.Equal(t, "pass:word", password)
+}
+
+func TestPasswordSource_Arg_File(t *testing.T) {
+	// Given
+	pth := filepath.Join(t.TempDir(), "password.txt")
+	require.NoError(t, os.WriteFile(pth, []byte("secret\nignored"), 0600))
+	source := PasswordSource{Kind: PasswordFile, Value: pth}
+
+	// When
+	arg, err := source.Arg(nil, t.TempDir(), "password")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "file:"+pth, arg)
+	password, err := PasswordFromArg(arg)
+	require.NoError(t, err)
+	require.Equal(t, "secret", password)
+}
+
+func TestPasswordSource_Arg_Legacy(t *testing.T) {
+	// Given
+	pth := filepath.Join(t.TempDir(), "password.txt")
+	require.NoError(t, os.WriteFile(pth, []byte("secret\n"), 0600))
+	dir := t.TempDir()
+
+	// When
+	fileArg, fileErr := PasswordSource{Kind: PasswordLegacy, Value: "file:" + pth}.Arg(nil, dir, "file_password")
+	passArg, passErr := PasswordSource{Kind: PasswordLegacy, Value: "pass:word"}.Arg(nil, dir, "pass_password")
+	plainArg, plainErr := PasswordSource{Kind: PasswordLegacy, Value: "word"}.Arg(nil, dir, "plain_password")
+
+	// Then
+	require.NoError(t, fileErr)
+	require.Equal(t, "file:"+pth, fileArg)
+	require.NoError(t, passErr)
+	require.Equal(t, "file:"+filepath.Join(dir, "pass_password"), passArg)
+	require.NoError(t, plainErr)
+	for _, arg := range []string{passArg, plainArg} {
+		password, err := PasswordFromArg(arg)
+		require.NoError(t, err)
+		require.Equal(t, "word", password)
+	}
+}
+
+func TestPasswordSource_Read_Stdin(t *testing.T) {
+	// Given
+	stdin := bufio.NewReader(strings.NewReader("store\r\nkey"))
+	source := PasswordSource{Kind: PasswordStdin}
+
+	// When
+	storePassword, storeErr := source.Read(stdin)
+	keyPassword, keyErr := source.Read(stdin)
+	_, eofErr := source.Read(stdin)
+
+	// Then
+	require.NoError(t, storeErr)
+	require.Equal(t, "store", storePassword)
+	require.NoError(t, keyErr)
+	require.Equal(t, "key", keyPassword)
+	require.EqualError(t, eofErr, "failed to read password from stdin: EOF")
+}
+
+func TestPasswordSource_Read_MissingEnv(t *testing.T) {
+	// Given
+	source := PasswordSource{Kind: PasswordEnv, Value: "BUNDLETOOL_TEST_UNSET_PASSWORD"}
+
+	// When
+	_, err := source.Read(nil)
+
+	// Then
+	require.EqualError(t, err, "password environment variable (BUNDLETOOL_TEST_UNSET_PASSWORD) is not set")
+}
@@ -0,0 +1,86 @@
+package bundletool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Protocol buffer wire types, based on: https://protobuf.dev/programming-guides/encoding/#structure
+const (
+	wireVarint          = 0
+	wireFixed64         = 1
+	wireLengthDelimited = 2
+	wireFixed32         = 5
+)
+
+var errTruncatedMessage = errors.New("truncated protobuf message")
+
+// protoField is a single field of a protobuf message.
+type protoField struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+// decodeProtoFields splits a serialized protobuf message into its fields.
+func decodeProtoFields(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errTruncatedMessage
+		}
+		data = data[n:]
+
+		field := protoField{number: int(key >> 3), wireType: int(key & 7)}
+		switch field.wireType {
+		case wireVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errTruncatedMessage
+			}
+			field.varint = v
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, errTruncatedMessage
+			}
+			field.varint = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireLengthDelimited:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return nil, errTruncatedMessage
+			}
+			field.bytes = data[n : n+int(l)]
+			data = data[n+int(l):]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, errTruncatedMessage
+			}
+			field.varint = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type: %d", field.wireType)
+		}
+
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// decodeProtoMessage calls fn for each field of a serialized protobuf message.
+func decodeProtoMessage(data []byte, fn func(field protoField) error) error {
+	fields, err := decodeProtoFields(data)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+package bundletool
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// TableOfContentsName is the name of the .apks archive entry describing its content.
+const TableOfContentsName = "toc.pb"
+
+// APKKind tells how an APK of an APK set is installed.
+type APKKind string
+
+// APK kinds, based on the ApkDescription message's metadata.
+const (
+	APKKindUnknown    APKKind = ""
+	APKKindSplit      APKKind = "split"
+	APKKindStandalone APKKind = "standalone"
+	APKKindInstant    APKKind = "instant"
+	APKKindSystem     APKKind = "system"
+	APKKindAssetSlice APKKind = "asset_slice"
+	APKKindApex       APKKind = "apex"
+)
+
+// BuildAPKsResult is the table of contents of an .apks archive.
+// It holds the subset of bundletool's BuildApksResult protobuf message
+// (https://github.com/google/bundletool/blob/master/src/main/proto/commands.proto) used by the Step.
+type BuildAPKsResult struct {
+	PackageName       string
+	BundletoolVersion string
+	Variants          []Variant
+}
+
+// Variant is a set of APKs targeting a device configuration.
+type Variant struct {
+	Number    int
+	Targeting Targeting
+	APKSets   []APKSet
+}
+
+// APKSet holds the APKs generated for a module.
+type APKSet struct {
+	ModuleName   string
+	Dependencies []string
+	APKs         []APKDescription
+}
+
+// APKDescription describes an APK of the archive.
+type APKDescription struct {
+	// Path is the APK's path inside the .apks archive.
+	Path      string
+	Kind      APKKind
+	Targeting Targeting
+	// SplitID and IsMasterSplit are set for split APKs.
+	SplitID       string
+	IsMasterSplit bool
+	// FusedModules lists the modules merged into a standalone or system APK.
+	FusedModules []string
+}
+
+// Targeting describes the device configurations an APK or variant is served to.
+type Targeting struct {
+	// MinSDKVersions lists the minimum SDK versions targeted.
+	MinSDKVersions  []int
+	ABIs            []string
+	ScreenDensities []string
+	Languages       []string
+}
+
+// String returns a human readable summary of the targeting.
+func (targeting Targeting) String() string {
+	var parts []string
+	if len(targeting.MinSDKVersions) > 0 {
+		var sdks []string
+		for _, sdk := range targeting.MinSDKVersions {
+			sdks = append(sdks, strconv.Itoa(sdk)+"+")
+		}
+		parts = append(parts, "SDK: "+strings.Join(sdks, ","))
+	}
+	if len(targeting.ABIs) > 0 {
+		parts = append(parts, "ABI: "+strings.Join(targeting.ABIs, ","))
+	}
+	if len(targeting.ScreenDensities) > 0 {
+		parts = append(parts, "density: "+strings.Join(targeting.ScreenDensities, ","))
+	}
+	if len(targeting.Languages) > 0 {
+		parts = append(parts, "language: "+strings.Join(targeting.Languages, ","))
+	}
+	if len(parts) == 0 {
+		return "all devices"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// StandaloneAPKs returns the standalone APKs of the archive, for example the universal APK.
+func (result BuildAPKsResult) StandaloneAPKs() []APKDescription {
+	var apks []APKDescription
+	for _, variant := range result.Variants {
+		for _, apkSet := range variant.APKSets {
+			for _, apk := range apkSet.APKs {
+				if apk.Kind == APKKindStandalone {
+					apks = append(apks, apk)
+				}
+			}
+		}
+	}
+	return apks
+}
+
+// ReadTableOfContents reads and decodes the table of contents of an .apks archive.
+func ReadTableOfContents(apksPath string) (BuildAPKsResult, error) {
+	reader, err := zip.OpenReader(apksPath)
+	if err != nil {
+		return BuildAPKsResult{}, err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Warnf("Failed to close archive (%s): %s", apksPath, err)
+		}
+	}()
+
+	for _, file := range reader.File {
+		if file.Name != TableOfContentsName {
+			continue
+		}
+
+		r, err := file.Open()
+		if err != nil {
+			return BuildAPKsResult{}, err
+		}
+		data, err := ioutil.ReadAll(r)
+		if closeErr := r.Close(); closeErr != nil {
+			log.Warnf("Failed to close archive entry (%s): %s", file.Name, closeErr)
+		}
+		if err != nil {
+			return BuildAPKsResult{}, err
+		}
+
+		return DecodeTableOfContents(data)
+	}
+
+	return BuildAPKsResult{}, fmt.Errorf("%s not found in archive (%s)", TableOfContentsName, apksPath)
+}
+
+// DecodeTableOfContents decodes a serialized BuildApksResult message, unknown fields are skipped.
+func DecodeTableOfContents(data []byte) (BuildAPKsResult, error) {
+	var result BuildAPKsResult
+	err := decodeProtoMessage(data, func(field protoField) error {
+		switch field.number {
+		case 1:
+			variant, err := decodeVariant(field.bytes)
+			if err != nil {
+				return err
+			}
+			result.Variants = append(result.Variants, variant)
+		case 2:
+			return decodeProtoMessage(field.bytes, func(field protoField) error {
+				if field.number == 2 {
+					result.BundletoolVersion = string(field.bytes)
+				}
+				return nil
+			})
+		case 4:
+			result.PackageName = string(field.bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return BuildAPKsResult{}, fmt.Errorf("failed to decode %s: %w", TableOfContentsName, err)
+	}
+	return result, nil
+}
+
+func decodeVariant(data []byte) (Variant, error) {
+	var variant Variant
+	err := decodeProtoMessage(data, func(field protoField) error {
+		switch field.number {
+		case 1:
+			targeting, err := decodeVariantTargeting(field.bytes)
+			if err != nil {
+				return err
+			}
+			variant.Targeting = targeting
+		case 2:
+			apkSet, err := decodeAPKSet(field.bytes)
+			if err != nil {
+				return err
+			}
+			variant.APKSets = append(variant.APKSets, apkSet)
+		case 3:
+			variant.Number = int(field.varint)
+		}
+		return nil
+	})
+	return variant, err
+}
+
+func decodeAPKSet(data []byte) (APKSet, error) {
+	var apkSet APKSet
+	err := decodeProtoMessage(data, func(field protoField) error {
+		switch field.number {
+		case 1:
+			return decodeProtoMessage(field.bytes, func(field protoField) error {
+				switch field.number {
+				case 1:
+					apkSet.ModuleName = string(field.bytes)
+				case 3:
+					apkSet.Dependencies = append(apkSet.Dependencies, string(field.bytes))
+				}
+				return nil
+			})
+		case 2:
+			apk, err := decodeAPKDescription(field.bytes)
+			if err != nil {
+				return err
+			}
+			apkSet.APKs = append(apkSet.APKs, apk)
+		}
+		return nil
+	})
+	return apkSet, err
+}
+
+// apkKinds maps the ApkDescription message's metadata field numbers to APK kinds.
+var apkKinds = map[int]APKKind{
+	3: APKKindSplit,
+	4: APKKindStandalone,
+	5: APKKindInstant,
+	6: APKKindSystem,
+	7: APKKindAssetSlice,
+	8: APKKindApex,
+}
+
+func decodeAPKDescription(data []byte) (APKDescription, error) {
+	var apk APKDescription
+	err := decodeProtoMessage(data, func(field protoField) error {
+		switch field.number {
+		case 1:
+			targeting, err := decodeAPKTargeting(field.bytes)
+			if err != nil {
+				return err
+			}
+			apk.Targeting = targeting
+		case 2:
+			apk.Path = string(field.bytes)
+		case 3, 5:
+			// SplitApkMetadata and InstantApkMetadata
+			apk.Kind = apkKinds[field.number]
+			return decodeProtoMessage(field.bytes, func(field protoField) error {
+				switch field.number {
+				case 1:
+					apk.SplitID = string(field.bytes)
+				case 2:
+					apk.IsMasterSplit = field.varint != 0
+				}
+				return nil
+			})
+		case 4, 6:
+			// StandaloneApkMetadata and SystemApkMetadata
+			apk.Kind = apkKinds[field.number]
+			return decodeProtoMessage(field.bytes, func(field protoField) error {
+				if field.number == 1 {
+					apk.FusedModules = append(apk.FusedModules, string(field.bytes))
+				}
+				return nil
+			})
+		case 7, 8:
+			apk.Kind = apkKinds[field.number]
+		}
+		return nil
+	})
+	return apk, err
+}
+
+// decodeVariantTargeting decodes a VariantTargeting message.
+func decodeVariantTargeting(data []byte) (Targeting, error) {
+	var targeting Targeting
+	err := decodeProtoMessage(data, func(field protoField) error {
+		var err error
+		switch field.number {
+		case 1:
+			targeting.MinSDKVersions, err = decodeSDKVersionTargeting(field.bytes)
+		case 2:
+			targeting.ABIs, err = decodeABITargeting(field.bytes)
+		case 3:
+			targeting.ScreenDensities, err = decodeScreenDensityTargeting(field.bytes)
+		}
+		return err
+	})
+	return targeting, err
+}
+
+// decodeAPKTargeting decodes an ApkTargeting message.
+func decodeAPKTargeting(data []byte) (Targeting, error) {
+	var targeting Targeting
+	err := decodeProtoMessage(data, func(field protoField) error {
+		var err error
+		switch field.number {
+		case 1:
+			targeting.ABIs, err = decodeABITargeting(field.bytes)
+		case 3:
+			targeting.Languages, err = decodeLanguageTargeting(field.bytes)
+		case 4:
+			targeting.ScreenDensities, err = decodeScreenDensityTargeting(field.bytes)
+		case 5:
+			targeting.MinSDKVersions, err = decodeSDKVersionTargeting(field.bytes)
+		}
+		return err
+	})
+	return targeting, err
+}
+
+func decodeSDKVersionTargeting(data []byte) ([]int, error) {
+	var versions []int
+	err := decodeProtoMessage(data, func(field protoField) error {
+		if field.number != 1 {
+			return nil
+		}
+		// SdkVersion.min is a google.protobuf.Int32Value wrapper
+		return decodeProtoMessage(field.bytes, func(field protoField) error {
+			if field.number != 1 {
+				return nil
+			}
+			return decodeProtoMessage(field.bytes, func(field protoField) error {
+				if field.number == 1 {
+					versions = append(versions, int(int32(field.varint)))
+				}
+				return nil
+			})
+		})
+	})
+	return versions, err
+}
+
+// abiAliases maps the Abi.AbiAlias enum values to ABI names.
+var abiAliases = map[uint64]string{
+	1: "armeabi",
+	2: "armeabi-v7a",
+	3: "arm64-v8a",
+	4: "x86",
+	5: "x86_64",
+	6: "mips",
+	7: "mips64",
+	8: "riscv64",
+}
+
+func decodeABITargeting(data []byte) ([]string, error) {
+	var abis []string
+	err := decodeProtoMessage(data, func(field protoField) error {
+		if field.number != 1 {
+			return nil
+		}
+		return decodeProtoMessage(field.bytes, func(field protoField) error {
+			if field.number == 1 {
+				abis = append(abis, enumName(abiAliases, field.varint))
+			}
+			return nil
+		})
+	})
+	return abis, err
+}
+
+// densityAliases maps the ScreenDensity.DensityAlias enum values to density names.
+var densityAliases = map[uint64]string{
+	1: "nodpi",
+	2: "ldpi",
+	3: "mdpi",
+	4: "tvdpi",
+	5: "hdpi",
+	6: "xhdpi",
+	7: "xxhdpi",
+	8: "xxxhdpi",
+}
+
+func decodeScreenDensityTargeting(data []byte) ([]string, error) {
+	var densities []string
+	err := decodeProtoMessage(data, func(field protoField) error {
+		if field.number != 1 {
+			return nil
+		}
+		return decodeProtoMessage(field.bytes, func(field protoField) error {
+			switch field.number {
+			case 1:
+				densities = append(densities, enumName(densityAliases, field.varint))
+			case 2:
+				densities = append(densities, strconv.Itoa(int(int32(field.varint)))+"dpi")
+			}
+			return nil
+		})
+	})
+	return densities, err
+}
+
+func decodeLanguageTargeting(data []byte) ([]string, error) {
+	var languages []string
+	err := decodeProtoMessage(data, func(field protoField) error {
+		if field.number == 1 {
+			languages = append(languages, string(field.bytes))
+		}
+		return nil
+	})
+	return languages, err
+}
+
+func enumName(names map[uint64]string, value uint64) string {
+	if name, ok := names[value]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", value)
+}
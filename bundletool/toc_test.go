@@ -0,0 +1,176 @@
+package bundletool
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DecodeTableOfContents_Universal(t *testing.T) {
+	// Given
+	data := givenUniversalTableOfContents()
+
+	// When
+	result, err := DecodeTableOfContents(data)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, BuildAPKsResult{
+		PackageName:       "com.example.app",
+		BundletoolVersion: "1.15.4",
+		Variants: []Variant{
+			{
+				Number:    0,
+				Targeting: Targeting{MinSDKVersions: []int{21}},
+				APKSets: []APKSet{
+					{
+						ModuleName: "base",
+						APKs: []APKDescription{
+							{
+								Path:         "universal.apk",
+								Kind:         APKKindStandalone,
+								Targeting:    Targeting{MinSDKVersions: []int{21}},
+								FusedModules: []string{"base", "feature"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, result)
+	require.Equal(t, "universal.apk", result.StandaloneAPKs()[0].Path)
+}
+
+func Test_DecodeTableOfContents_Splits(t *testing.T) {
+	// Given
+	abiTargeting := protoMessage(protoBytes(1, protoMessage(protoVarint(1, 3))))
+	densityTargeting := protoMessage(protoBytes(1, protoMessage(protoVarint(1, 7))), protoBytes(1, protoMessage(protoVarint(2, 560))))
+	languageTargeting := protoMessage(protoString(1, "fr"))
+	apkTargeting := protoMessage(protoBytes(1, abiTargeting), protoBytes(3, languageTargeting), protoBytes(4, densityTargeting))
+	splitAPK := protoMessage(
+		protoBytes(1, apkTargeting),
+		protoString(2, "splits/base-arm64_v8a.apk"),
+		protoBytes(3, protoMessage(protoString(1, "config.arm64_v8a"), protoVarint(2, 0))),
+	)
+	masterAPK := protoMessage(
+		protoString(2, "splits/base-master.apk"),
+		protoBytes(3, protoMessage(protoVarint(2, 1))),
+	)
+	apkSet := protoMessage(
+		protoBytes(1, protoMessage(protoString(1, "feature"), protoString(3, "base"))),
+		protoBytes(2, masterAPK),
+		protoBytes(2, splitAPK),
+	)
+	data := protoMessage(protoBytes(1, protoMessage(protoBytes(2, apkSet), protoVarint(3, 1))))
+
+	// When
+	result, err := DecodeTableOfContents(data)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []APKSet{
+		{
+			ModuleName:   "feature",
+			Dependencies: []string{"base"},
+			APKs: []APKDescription{
+				{Path: "splits/base-master.apk", Kind: APKKindSplit, IsMasterSplit: true},
+				{
+					Path:    "splits/base-arm64_v8a.apk",
+					Kind:    APKKindSplit,
+					SplitID: "config.arm64_v8a",
+					Targeting: Targeting{
+						ABIs:            []string{"arm64-v8a"},
+						ScreenDensities: []string{"xxhdpi", "560dpi"},
+						Languages:       []string{"fr"},
+					},
+				},
+			},
+		},
+	}, result.Variants[0].APKSets)
+	require.Equal(t, 1, result.Variants[0].Number)
+	require.Empty(t, result.StandaloneAPKs())
+}
+
+func Test_DecodeTableOfContents_Truncated(t *testing.T) {
+	data := givenUniversalTableOfContents()
+
+	_, err := DecodeTableOfContents(data[:len(data)-3])
+
+	require.EqualError(t, err, "failed to decode toc.pb: truncated protobuf message")
+}
+
+func Test_ReadTableOfContents(t *testing.T) {
+	// Given
+	pth := filepath.Join(t.TempDir(), "app.apks")
+	f, err := os.Create(pth)
+	require.NoError(t, err)
+	w := zip.NewWriter(f)
+	entry, err := w.Create(TableOfContentsName)
+	require.NoError(t, err)
+	_, err = entry.Write(givenUniversalTableOfContents())
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	// When
+	result, err := ReadTableOfContents(pth)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "com.example.app", result.PackageName)
+}
+
+func Test_Targeting_String(t *testing.T) {
+	require.Equal(t, "all devices", Targeting{}.String())
+	require.Equal(t, "SDK: 21+, ABI: arm64-v8a,x86, density: xxhdpi, language: fr",
+		Targeting{MinSDKVersions: []int{21}, ABIs: []string{"arm64-v8a", "x86"}, ScreenDensities: []string{"xxhdpi"}, Languages: []string{"fr"}}.String())
+}
+
+func givenUniversalTableOfContents() []byte {
+	sdkTargeting := protoMessage(protoBytes(1, protoMessage(protoBytes(1, protoMessage(protoVarint(1, 21))))))
+	universalAPK := protoMessage(
+		protoBytes(1, protoMessage(protoBytes(5, sdkTargeting))),
+		protoString(2, "universal.apk"),
+		protoBytes(4, protoMessage(protoString(1, "base"), protoString(1, "feature"))),
+	)
+	apkSet := protoMessage(
+		protoBytes(1, protoMessage(protoString(1, "base"))),
+		protoBytes(2, universalAPK),
+	)
+	variant := protoMessage(
+		protoBytes(1, protoMessage(protoBytes(1, sdkTargeting))),
+		protoBytes(2, apkSet),
+	)
+	return protoMessage(
+		protoBytes(1, variant),
+		protoBytes(2, protoMessage(protoString(2, "1.15.4"))),
+		protoString(4, "com.example.app"),
+	)
+}
+
+func protoMessage(fields ...[]byte) []byte {
+	var message []byte
+	for _, field := range fields {
+		message = append(message, field...)
+	}
+	return message
+}
+
+func protoVarint(number int, value uint64) []byte {
+	b := binary.AppendUvarint(nil, uint64(number<<3|wireVarint))
+	return binary.AppendUvarint(b, value)
+}
+
+func protoBytes(number int, value []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(number<<3|wireLengthDelimited))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func protoString(number int, value string) []byte {
+	return protoBytes(number, []byte(value))
+}
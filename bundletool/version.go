@@ -0,0 +1,293 @@
+package bundletool
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// GithubReleasesAPIURL lists bundletool releases in the Github releases API format.
+	GithubReleasesAPIURL = "https://api.github.com/repos/google/bundletool/releases"
+	// LatestVersion can be used as version constraint to select the newest stable release.
+	LatestVersion = "latest"
+)
+
+// HTTPClient is a type that can perform a HTTP GET request.
+type HTTPClient interface {
+	Get(url string) (*http.Response, error)
+}
+
+type release struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// semver is a parsed major.minor.patch version.
+type semver struct {
+	major, minor, patch int
+}
+
+// parseSemver parses versions like 1.8.1 or v1.8.1, missing minor and patch components default to 0.
+func parseSemver(s string) (semver, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(s), "v"), ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return semver{}, fmt.Errorf("invalid version: %s", s)
+	}
+
+	var components [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, fmt.Errorf("invalid version: %s", s)
+		}
+		components[i] = n
+	}
+	return semver{components[0], components[1], components[2]}, nil
+}
+
+// compare returns -1, 0 or 1 if v is lower, equal or greater than other.
+func (v semver) compare(other semver) int {
+	for _, d := range []int{v.major - other.major, v.minor - other.minor, v.patch - other.patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionConstraint is a single condition of a version range, for example >=1.15 or 1.x.
+type versionConstraint struct {
+	operator string
+	version  semver
+	// wildcard is the number of leading version components to match, used by the 1.x and 1.15.x forms.
+	wildcard int
+}
+
+var operators = []string{">=", "<=", ">", "<", "="}
+
+func parseConstraint(s string) (versionConstraint, error) {
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			v, err := parseSemver(strings.TrimPrefix(s, op))
+			if err != nil {
+				return versionConstraint{}, err
+			}
+			return versionConstraint{operator: op, version: v}, nil
+		}
+	}
+
+	parts := strings.Split(s, ".")
+	for i, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			if i != len(parts)-1 {
+				return versionConstraint{}, fmt.Errorf("invalid version constraint: %s", s)
+			}
+			if i == 0 {
+				return versionConstraint{operator: ">=", version: semver{}}, nil
+			}
+			v, err := parseSemver(strings.Join(parts[:i], "."))
+			if err != nil {
+				return versionConstraint{}, err
+			}
+			return versionConstraint{operator: "=", version: v, wildcard: i}, nil
+		}
+	}
+
+	v, err := parseSemver(s)
+	if err != nil {
+		return versionConstraint{}, err
+	}
+	return versionConstraint{operator: "=", version: v}, nil
+}
+
+func (c versionConstraint) matches(v semver) bool {
+	if c.wildcard > 0 {
+		if v.major != c.version.major {
+			return false
+		}
+		return c.wildcard == 1 || v.minor == c.version.minor
+	}
+
+	cmp := v.compare(c.version)
+	switch c.operator {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	default:
+		return cmp == 0
+	}
+}
+
+// parseConstraints parses a space or comma separated list of version constraints, all of them have to match.
+// An operator may be separated from its version by whitespace, for example >= 1.15.
+func parseConstraints(s string) ([]versionConstraint, error) {
+	var constraints []versionConstraint
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if isOperator(field) && i+1 < len(fields) {
+			i++
+			field += fields[i]
+		}
+		c, err := parseConstraint(field)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, c)
+	}
+	return constraints, nil
+}
+
+func isOperator(s string) bool {
+	for _, op := range operators {
+		if s == op {
+			return true
+		}
+	}
+	return false
+}
+
+// isExactVersion returns true if the given version needs no resolution.
+func isExactVersion(s string) bool {
+	if s == "" || s == LatestVersion {
+		return false
+	}
+	for _, c := range []string{"x", "X", "*", ">", "<", "=", " ", ","} {
+		if strings.Contains(s, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveVersion resolves a bundletool version constraint to an exact release version.
+// Supported constraints: an exact version (returned as is), `latest`, wildcards (1.x, 1.15.x)
+// and comparisons (>=1.15, >=1.15 <2). Wildcard and comparison constraints select the newest matching
+// stable release from the Github releases compatible JSON listing served at releasesURL.
+func ResolveVersion(constraint string, client HTTPClient, releasesURL string) (string, error) {
+	constraint = strings.TrimSpace(constraint)
+	if isExactVersion(constraint) {
+		return constraint, nil
+	}
+
+	var constraints []versionConstraint
+	if constraint != "" && constraint != LatestVersion {
+		var err error
+		if constraints, err = parseConstraints(constraint); err != nil {
+			return "", err
+		}
+	}
+
+	releases, err := listReleases(client, releasesURL)
+	if err != nil {
+		return "", err
+	}
+
+	resolved := ""
+	var resolvedVersion semver
+	for _, r := range releases {
+		if r.Draft || r.Prerelease {
+			continue
+		}
+		v, err := parseSemver(r.TagName)
+		if err != nil {
+			log.Debugf("Skipping release with non semantic version tag: %s", r.TagName)
+			continue
+		}
+		if !matchesAll(constraints, v) {
+			continue
+		}
+		if resolved == "" || v.compare(resolvedVersion) > 0 {
+			resolved = r.TagName
+			resolvedVersion = v
+		}
+	}
+
+	if resolved == "" {
+		return "", fmt.Errorf("no bundletool release matches version constraint: %s", constraint)
+	}
+	return resolved, nil
+}
+
+func matchesAll(constraints []versionConstraint, v semver) bool {
+	for _, c := range constraints {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// maxReleasePages limits the number of release listing pages followed, in case a mirror links the pages in a loop.
+const maxReleasePages = 50
+
+// nextPagePattern matches the next page's URL in a Link header, like: <https://api.github.com/...?page=2>; rel="next"
+var nextPagePattern = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="next"`)
+
+// listReleases returns the releases of all the listing's pages, following the Link headers like the Github API paginates.
+func listReleases(client HTTPClient, releasesURL string) ([]release, error) {
+	url := releasesURL
+	if !strings.Contains(url, "?") {
+		url += "?per_page=100"
+	}
+
+	var releases []release
+	for page := 0; url != ""; page++ {
+		if page == maxReleasePages {
+			log.Warnf("Stopped listing bundletool releases after %d pages", maxReleasePages)
+			break
+		}
+
+		pageReleases, next, err := listReleasesPage(client, url, releasesURL)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, pageReleases...)
+		url = next
+	}
+	return releases, nil
+}
+
+// listReleasesPage returns the releases of a single listing page, and the next page's URL if any.
+func listReleasesPage(client HTTPClient, url, releasesURL string) ([]release, string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Errorf("Failed to close body, error: %s", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unable to list bundletool releases from: %s. Status code: %d", releasesURL, resp.StatusCode)
+	}
+
+	var releases []release
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, "", fmt.Errorf("failed to parse bundletool releases from: %s: %w", releasesURL, err)
+	}
+
+	next := ""
+	if match := nextPagePattern.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+		next = match[1]
+	}
+	return releases, next, nil
+}
@@ -0,0 +1,120 @@
+package bundletool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const releasesJSON = `[
+  {"tag_name": "2.0.0-alpha01", "prerelease": true},
+  {"tag_name": "1.15.6"},
+  {"tag_name": "1.16.0", "draft": true},
+  {"tag_name": "1.15.4"},
+  {"tag_name": "1.8.1"},
+  {"tag_name": "0.15.0"}
+]`
+
+func Test_ResolveVersion(t *testing.T) {
+	svr := givenReleasesServer(t)
+
+	scenarios := []struct {
+		constraint string
+		expected   string
+	}{
+		{constraint: "", expected: "1.15.6"},
+		{constraint: "latest", expected: "1.15.6"},
+		{constraint: "1.x", expected: "1.15.6"},
+		{constraint: "0.x", expected: "0.15.0"},
+		{constraint: "1.8.x", expected: "1.8.1"},
+		{constraint: ">=1.15", expected: "1.15.6"},
+		{constraint: ">=1.8 <1.15", expected: "1.8.1"},
+		{constraint: ">1.8.1,<=1.15.4", expected: "1.15.4"},
+		{constraint: ">= 1.15", expected: "1.15.6"},
+		{constraint: ">= 1.8, < 1.15", expected: "1.8.1"},
+	}
+
+	for _, scenario := range scenarios {
+		actual, err := ResolveVersion(scenario.constraint, http.DefaultClient, svr.URL)
+
+		require.NoError(t, err, scenario.constraint)
+		require.Equal(t, scenario.expected, actual, scenario.constraint)
+	}
+}
+
+func Test_ResolveVersion_ExactVersionWithoutRequest(t *testing.T) {
+	// When
+	actual, err := ResolveVersion("1.8.1", http.DefaultClient, "http://invalid.invalid")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "1.8.1", actual)
+}
+
+func Test_ResolveVersion_NoMatch(t *testing.T) {
+	// Given
+	svr := givenReleasesServer(t)
+
+	// When
+	_, err := ResolveVersion(">=3", http.DefaultClient, svr.URL)
+
+	// Then
+	require.EqualError(t, err, "no bundletool release matches version constraint: >=3")
+}
+
+func Test_ResolveVersion_InvalidConstraint(t *testing.T) {
+	// When
+	_, err := ResolveVersion(">=one", http.DefaultClient, "http://invalid.invalid")
+
+	// Then
+	require.EqualError(t, err, "invalid version: one")
+}
+
+func Test_ResolveVersion_InvalidStatusCode(t *testing.T) {
+	// Given
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer svr.Close()
+
+	// When
+	_, err := ResolveVersion("latest", http.DefaultClient, svr.URL)
+
+	// Then
+	require.EqualError(t, err, "unable to list bundletool releases from: "+svr.URL+". Status code: 404")
+}
+
+func Test_ResolveVersion_FollowsPages(t *testing.T) {
+	// Given
+	var svr *httptest.Server
+	svr = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := `[{"tag_name": "0.9.0"}]`
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", `<`+svr.URL+`/?per_page=100&page=2>; rel="next", <`+svr.URL+`/?per_page=100&page=2>; rel="last"`)
+			content = releasesJSON
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Errorf("Failed to write response: %s", err)
+		}
+	}))
+	t.Cleanup(svr.Close)
+
+	// When
+	actual, err := ResolveVersion("<0.10", http.DefaultClient, svr.URL)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "0.9.0", actual)
+}
+
+func givenReleasesServer(t *testing.T) *httptest.Server {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte(releasesJSON)); err != nil {
+			t.Errorf("Failed to write response: %s", err)
+		}
+	}))
+	t.Cleanup(svr.Close)
+	return svr
+}
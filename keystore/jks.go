@@ -0,0 +1,187 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// JKS format, based on the OpenJDK's sun.security.provider.JavaKeyStore
+const (
+	jksMagic   uint32 = 0xfeedfeed
+	jceksMagic uint32 = 0xcececece
+
+	jksTagPrivateKey         uint32 = 1
+	jksTagTrustedCertificate uint32 = 2
+
+	// jksIntegritySalt is mixed into the keystore's integrity digest.
+	jksIntegritySalt = "Mighty Aphrodite"
+	jksDigestSize    = sha1.Size
+)
+
+// oidJKSKeyProtector identifies the proprietary key protection algorithm of JKS private keys.
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+var errTruncatedJKS = errors.New("truncated JKS keystore")
+
+// jksReader reads the big endian fields of a JKS keystore.
+type jksReader struct {
+	data []byte
+	err  error
+}
+
+func (r *jksReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = errTruncatedJKS
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *jksReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *jksReader) utf() string {
+	b := r.next(2)
+	if b == nil {
+		return ""
+	}
+	return string(r.next(int(binary.BigEndian.Uint16(b))))
+}
+
+func parseJKS(data []byte, storePassword string) (*Keystore, error) {
+	if len(data) < jksDigestSize {
+		return nil, errTruncatedJKS
+	}
+
+	content, digest := data[:len(data)-jksDigestSize], data[len(data)-jksDigestSize:]
+	h := sha1.New()
+	h.Write(javaPasswordBytes(storePassword))
+	h.Write([]byte(jksIntegritySalt))
+	h.Write(content)
+	if subtle.ConstantTimeCompare(h.Sum(nil), digest) != 1 {
+		return nil, ErrWrongStorePassword
+	}
+
+	r := &jksReader{data: content}
+	r.uint32() // magic
+	version := r.uint32()
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("unsupported JKS version: %d", version)
+	}
+	readCertificate := func() []byte {
+		if version == 2 {
+			r.utf() // certificate type
+		}
+		return r.next(int(r.uint32()))
+	}
+
+	keystore := &Keystore{Type: TypeJKS}
+	count := r.uint32()
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		tag := r.uint32()
+		e := entry{alias: r.utf()}
+		r.next(8) // creation date
+
+		switch tag {
+		case jksTagPrivateKey:
+			protectedKey := r.next(int(r.uint32()))
+			chainLength := r.uint32()
+			for j := uint32(0); j < chainLength && r.err == nil; j++ {
+				if certificate := readCertificate(); j == 0 {
+					e.certificate = certificate
+				}
+			}
+			e.decryptKey = func(password string) error {
+				_, err := recoverJKSKey(protectedKey, password)
+				return err
+			}
+		case jksTagTrustedCertificate:
+			e.certificate = readCertificate()
+		default:
+			return nil, fmt.Errorf("unsupported JKS entry type: %d", tag)
+		}
+		keystore.entries = append(keystore.entries, e)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return keystore, nil
+}
+
+// recoverJKSKey decrypts a private key protected by the JKS key protector:
+// the key is XOR-ed with a SHA-1 based key stream, and followed by the SHA-1 digest of the password and the plain key.
+func recoverJKSKey(protectedKey []byte, password string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(protectedKey, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse protected key: %w", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidJKSKeyProtector) {
+		return nil, fmt.Errorf("%w: key protection %s", ErrUnsupportedAlgorithm, info.Algorithm.Algorithm)
+	}
+	if len(info.EncryptedData) < 2*sha1.Size {
+		return nil, errors.New("protected key is too short")
+	}
+
+	passwordBytes := javaPasswordBytes(password)
+	salt := info.EncryptedData[:sha1.Size]
+	encrypted := info.EncryptedData[sha1.Size : len(info.EncryptedData)-sha1.Size]
+	check := info.EncryptedData[len(info.EncryptedData)-sha1.Size:]
+
+	plain := jksKeyStream(passwordBytes, salt, len(encrypted))
+	for i := range plain {
+		plain[i] ^= encrypted[i]
+	}
+
+	h := sha1.New()
+	h.Write(passwordBytes)
+	h.Write(plain)
+	if subtle.ConstantTimeCompare(h.Sum(nil), check) != 1 {
+		return nil, ErrWrongKeyPassword
+	}
+	return plain, nil
+}
+
+func jksKeyStream(passwordBytes, salt []byte, size int) []byte {
+	var stream []byte
+	digest := salt
+	for len(stream) < size {
+		h := sha1.New()
+		h.Write(passwordBytes)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		stream = append(stream, digest...)
+	}
+	return stream[:size]
+}
+
+// javaPasswordBytes returns the password's UTF-16 code units as big endian bytes, like Java chars are hashed.
+func javaPasswordBytes(password string) []byte {
+	var b bytes.Buffer
+	for _, unit := range utf16.Encode([]rune(password)) {
+		b.WriteByte(byte(unit >> 8))
+		b.WriteByte(byte(unit))
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,191 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse_JKS(t *testing.T) {
+	// Given
+	data := givenJKS(t, "storepass", []jksTestEntry{
+		{alias: "release", keyPassword: "keypass"},
+		{alias: "upload", keyPassword: "keypass"},
+		{alias: "ca"},
+	})
+
+	// When
+	keystore, err := Parse(data, "storepass")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, TypeJKS, keystore.Type)
+	require.Equal(t, []string{"ca", "release", "upload"}, keystore.Aliases())
+	require.Equal(t, []string{"release", "upload"}, keystore.PrivateKeyAliases())
+	require.NoError(t, keystore.CheckKey("Release", "keypass"))
+	require.EqualError(t, keystore.CheckKey("release", "storepass"), "alias (release): private key password is incorrect")
+	require.EqualError(t, keystore.CheckKey("ca", "keypass"), "alias (ca) is not a private key entry")
+	require.EqualError(t, keystore.CheckKey("missing", "keypass"), "alias (missing) not found in keystore, available aliases: ca, release, upload")
+}
+
+func TestKeystore_SigningKeyAlias(t *testing.T) {
+	// Given
+	keystore, err := Parse(givenJKS(t, "storepass", []jksTestEntry{
+		{alias: "ca"},
+		{alias: "release", keyPassword: "keypass"},
+	}), "storepass")
+	require.NoError(t, err)
+
+	// When
+	alias, err := keystore.SigningKeyAlias()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "release", alias)
+}
+
+func TestKeystore_SigningKeyAlias_MultipleKeys(t *testing.T) {
+	// Given
+	keystore, err := Parse(givenJKS(t, "storepass", []jksTestEntry{
+		{alias: "upload", keyPassword: "keypass"},
+		{alias: "release", keyPassword: "keypass"},
+	}), "storepass")
+	require.NoError(t, err)
+
+	// When
+	_, err = keystore.SigningKeyAlias()
+
+	// Then
+	require.EqualError(t, err, "keystore has multiple private key entries, select one of the aliases: release, upload")
+}
+
+func TestKeystore_Certificate(t *testing.T) {
+	// Given
+	_, certificate := givenKeyPair(t)
+	keystore, err := Parse(givenJKS(t, "storepass", []jksTestEntry{
+		{alias: "release", keyPassword: "keypass", certificate: certificate.Raw},
+		{alias: "upload", keyPassword: "keypass"},
+	}), "storepass")
+	require.NoError(t, err)
+
+	// When
+	keystoreCertificate, err := keystore.Certificate("Release")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, certificate.Raw, keystoreCertificate.Raw)
+	_, err = keystore.Certificate("upload")
+	require.EqualError(t, err, "alias (upload): the certificate can not be read")
+}
+
+func TestParse_JKSWrongStorePassword(t *testing.T) {
+	// Given
+	data := givenJKS(t, "storepass", []jksTestEntry{{alias: "release", keyPassword: "keypass"}})
+
+	// When
+	_, err := Parse(data, "wrong")
+
+	// Then
+	require.Equal(t, ErrWrongStorePassword, err)
+}
+
+func TestParse_JCEKS(t *testing.T) {
+	// When
+	_, err := Parse([]byte{0xce, 0xce, 0xce, 0xce, 0, 0, 0, 2}, "storepass")
+
+	// Then
+	require.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+type jksTestEntry struct {
+	alias string
+	// keyPassword is empty for trusted certificate entries
+	keyPassword string
+	// certificate is the private key's certificate chain, or the trusted certificate, "cert" by default
+	certificate []byte
+}
+
+// givenJKS creates a version 2 JKS keystore, private keys are random bytes without a certificate chain
+// unless the entry's certificate is set.
+func givenJKS(t *testing.T, storePassword string, entries []jksTestEntry) []byte {
+	var b bytes.Buffer
+	write := func(v interface{}) {
+		require.NoError(t, binary.Write(&b, binary.BigEndian, v))
+	}
+	writeUTF := func(s string) {
+		write(uint16(len(s)))
+		b.WriteString(s)
+	}
+
+	write(jksMagic)
+	write(uint32(2))
+	write(uint32(len(entries)))
+	for _, e := range entries {
+		if e.keyPassword == "" {
+			write(jksTagTrustedCertificate)
+			writeUTF(e.alias)
+			write(uint64(0))
+			certificate := e.certificate
+			if certificate == nil {
+				certificate = []byte("cert")
+			}
+			writeUTF("X.509")
+			write(uint32(len(certificate)))
+			b.Write(certificate)
+			continue
+		}
+
+		protectedKey := givenJKSProtectedKey(t, e.keyPassword)
+		write(jksTagPrivateKey)
+		writeUTF(e.alias)
+		write(uint64(0))
+		write(uint32(len(protectedKey)))
+		b.Write(protectedKey)
+		if e.certificate == nil {
+			write(uint32(0))
+			continue
+		}
+		write(uint32(2))
+		for _, certificate := range [][]byte{e.certificate, []byte("issuer")} {
+			writeUTF("X.509")
+			write(uint32(len(certificate)))
+			b.Write(certificate)
+		}
+	}
+
+	h := sha1.New()
+	h.Write(javaPasswordBytes(storePassword))
+	h.Write([]byte(jksIntegritySalt))
+	h.Write(b.Bytes())
+	return h.Sum(b.Bytes())
+}
+
+func givenJKSProtectedKey(t *testing.T, password string) []byte {
+	key := make([]byte, 64)
+	salt := make([]byte, sha1.Size)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	_, err = rand.Read(salt)
+	require.NoError(t, err)
+
+	encrypted := jksKeyStream(javaPasswordBytes(password), salt, len(key))
+	for i := range encrypted {
+		encrypted[i] ^= key[i]
+	}
+	h := sha1.New()
+	h.Write(javaPasswordBytes(password))
+	h.Write(key)
+
+	protectedKey, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: h.Sum(append(salt, encrypted...)),
+	})
+	require.NoError(t, err)
+	return protectedKey
+}
@@ -0,0 +1,145 @@
+// Package keystore reads JKS and PKCS #12 keystores, to validate the signing configuration before running bundletool.
+package keystore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Type is a keystore format.
+type Type string
+
+// Supported keystore formats.
+const (
+	TypeJKS    Type = "JKS"
+	TypePKCS12 Type = "PKCS12"
+)
+
+var (
+	// ErrUnsupportedFormat is returned for keystore formats which can not be read, for example JCEKS or BKS.
+	ErrUnsupportedFormat = errors.New("unsupported keystore format")
+	// ErrWrongStorePassword is returned if the keystore's integrity check fails with the given password.
+	ErrWrongStorePassword = errors.New("keystore password is incorrect")
+	// ErrWrongKeyPassword is returned if the private key can not be decrypted with the given password.
+	ErrWrongKeyPassword = errors.New("private key password is incorrect")
+	// ErrUnsupportedAlgorithm is returned if the keystore's integrity check or a private key uses an algorithm
+	// which can not be handled, for example an unusual PKCS #12 MAC or PBE cipher.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+)
+
+// Keystore is a parsed keystore, with its private keys still encrypted.
+type Keystore struct {
+	Type    Type
+	entries []entry
+}
+
+type entry struct {
+	alias string
+	// certificate is the DER encoded certificate of the entry, the first one of a private key entry's chain.
+	// It is nil if the certificate can not be read, for example if it is encrypted with RC2 in a legacy PKCS #12 keystore.
+	certificate []byte
+	// decryptKey decrypts the entry's private key, it is nil for trusted certificate entries.
+	decryptKey func(password string) error
+}
+
+// Open reads a JKS or PKCS #12 keystore and checks its integrity with the store password.
+func Open(pth, storePassword string) (*Keystore, error) {
+	data, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, storePassword)
+}
+
+// Parse parses a JKS or PKCS #12 keystore and checks its integrity with the store password.
+func Parse(data []byte, storePassword string) (*Keystore, error) {
+	if len(data) >= 4 {
+		switch binary.BigEndian.Uint32(data) {
+		case jksMagic:
+			return parseJKS(data, storePassword)
+		case jceksMagic:
+			return nil, fmt.Errorf("%w: JCEKS", ErrUnsupportedFormat)
+		}
+	}
+	if bytes.HasPrefix(data, []byte{0x30}) {
+		return parsePKCS12(data, storePassword)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// Aliases returns the sorted aliases of all the entries.
+func (keystore *Keystore) Aliases() []string {
+	var aliases []string
+	for _, e := range keystore.entries {
+		aliases = append(aliases, e.alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// PrivateKeyAliases returns the sorted aliases of the private key entries.
+func (keystore *Keystore) PrivateKeyAliases() []string {
+	var aliases []string
+	for _, e := range keystore.entries {
+		if e.decryptKey != nil {
+			aliases = append(aliases, e.alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// SigningKeyAlias returns the alias of the keystore's only private key entry.
+// It returns an error listing the aliases if the keystore has multiple private keys.
+func (keystore *Keystore) SigningKeyAlias() (string, error) {
+	aliases := keystore.PrivateKeyAliases()
+	switch len(aliases) {
+	case 0:
+		return "", errors.New("no private key entry found in keystore")
+	case 1:
+		return aliases[0], nil
+	default:
+		return "", fmt.Errorf("keystore has multiple private key entries, select one of the aliases: %s", strings.Join(aliases, ", "))
+	}
+}
+
+// CheckKey checks that the alias refers to a private key entry, which can be decrypted with the key password.
+// Aliases are case insensitive, like in keytool.
+func (keystore *Keystore) CheckKey(alias, keyPassword string) error {
+	for _, e := range keystore.entries {
+		if !strings.EqualFold(e.alias, alias) {
+			continue
+		}
+		if e.decryptKey == nil {
+			return fmt.Errorf("alias (%s) is not a private key entry", alias)
+		}
+		if err := e.decryptKey(keyPassword); err != nil {
+			return fmt.Errorf("alias (%s): %w", alias, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("alias (%s) not found in keystore, available aliases: %s", alias, strings.Join(keystore.Aliases(), ", "))
+}
+
+// Certificate returns the certificate of the entry, for a private key entry the first certificate of its chain.
+// Aliases are case insensitive, like in keytool.
+func (keystore *Keystore) Certificate(alias string) (*x509.Certificate, error) {
+	for _, e := range keystore.entries {
+		if !strings.EqualFold(e.alias, alias) {
+			continue
+		}
+		if e.certificate == nil {
+			return nil, fmt.Errorf("alias (%s): the certificate can not be read", alias)
+		}
+		return x509.ParseCertificate(e.certificate)
+	}
+
+	return nil, fmt.Errorf("alias (%s) not found in keystore, available aliases: %s", alias, strings.Join(keystore.Aliases(), ", "))
+}
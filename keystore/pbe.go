@@ -0,0 +1,258 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+)
+
+// Password based encryption algorithms, based on: https://datatracker.ietf.org/doc/html/rfc7292#appendix-C
+// and https://datatracker.ietf.org/doc/html/rfc8018#appendix-A.4
+var (
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd2KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 4}
+	oidPBES2                         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+)
+
+// pkcs12KDF IDs, based on: https://datatracker.ietf.org/doc/html/rfc7292#appendix-B.3
+const (
+	kdfIDKey byte = 1
+	kdfIDIV  byte = 2
+	kdfIDMAC byte = 3
+)
+
+var errDecryption = errors.New("decryption failed")
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPBE decrypts data encrypted with one of the PKCS #12 or PBES2 password based encryption schemes.
+func decryptPBE(algorithm pkix.AlgorithmIdentifier, password string, data []byte) ([]byte, error) {
+	var block cipher.Block
+	var iv []byte
+
+	switch {
+	case algorithm.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC), algorithm.Algorithm.Equal(oidPBEWithSHAAnd2KeyTripleDESCBC):
+		var params pbeParams
+		if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("failed to parse PBE parameters: %w", err)
+		}
+		passwordBytes := bmpPassword(password)
+		keySize := 24
+		if algorithm.Algorithm.Equal(oidPBEWithSHAAnd2KeyTripleDESCBC) {
+			keySize = 16
+		}
+		key := pkcs12KDF(sha1.New, passwordBytes, params.Salt, kdfIDKey, params.Iterations, keySize)
+		if keySize == 16 {
+			key = append(key, key[:8]...)
+		}
+		iv = pkcs12KDF(sha1.New, passwordBytes, params.Salt, kdfIDIV, params.Iterations, des.BlockSize)
+
+		var err error
+		if block, err = des.NewTripleDESCipher(key); err != nil {
+			return nil, err
+		}
+	case algorithm.Algorithm.Equal(oidPBES2):
+		var err error
+		if block, iv, err = pbes2Cipher(algorithm, password); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm.Algorithm)
+	}
+
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 || len(iv) != block.BlockSize() {
+		return nil, errDecryption
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	return unpad(plain, block.BlockSize())
+}
+
+func pbes2Cipher(algorithm pkix.AlgorithmIdentifier, password string) (cipher.Block, []byte, error) {
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse PBES2 parameters: %w", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, nil, fmt.Errorf("%w: key derivation function %s", ErrUnsupportedAlgorithm, params.KeyDerivationFunc.Algorithm)
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse PBKDF2 parameters: %w", err)
+	}
+
+	prf := sha1.New
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0, kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, nil, fmt.Errorf("%w: PRF %s", ErrUnsupportedAlgorithm, kdfParams.PRF.Algorithm)
+	}
+
+	var keySize int
+	newCipher := aes.NewCipher
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keySize = 16
+	case scheme.Equal(oidAES192CBC):
+		keySize = 24
+	case scheme.Equal(oidAES256CBC):
+		keySize = 32
+	case scheme.Equal(oidDESEDE3CBC):
+		keySize = 24
+		newCipher = des.NewTripleDESCipher
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, scheme)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse IV: %w", err)
+	}
+
+	key := pbkdf2Key(prf, []byte(password), kdfParams.Salt, kdfParams.IterationCount, keySize)
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, iv, nil
+}
+
+// pbkdf2Key derives a key, based on: https://datatracker.ietf.org/doc/html/rfc8018#section-5.2
+func pbkdf2Key(newHash func() hash.Hash, password, salt []byte, iterations, size int) []byte {
+	prf := hmac.New(newHash, password)
+	var key []byte
+	for blockIndex := uint32(1); len(key) < size; blockIndex++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, blockIndex))
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:size]
+}
+
+// pkcs12KDF derives key material, based on: https://datatracker.ietf.org/doc/html/rfc7292#appendix-B.2
+func pkcs12KDF(newHash func() hash.Hash, password, salt []byte, id byte, iterations, size int) []byte {
+	v := newHash().BlockSize()
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	input := append(fillBlocks(salt, v), fillBlocks(password, v)...)
+
+	var result []byte
+	for len(result) < size {
+		h := newHash()
+		h.Write(d)
+		h.Write(input)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			h = newHash()
+			h.Write(a)
+			a = h.Sum(nil)
+		}
+		result = append(result, a...)
+
+		if len(result) < size {
+			b := fillBlocks(a, v)[:v]
+			for j := 0; j < len(input); j += v {
+				// input block = (input block + b + 1) mod 2^(v*8)
+				carry := 1
+				for k := v - 1; k >= 0; k-- {
+					sum := int(input[j+k]) + int(b[k]) + carry
+					input[j+k] = byte(sum)
+					carry = sum >> 8
+				}
+			}
+		}
+	}
+	return result[:size]
+}
+
+// fillBlocks repeats data to fill the smallest multiple of blockSize bytes it fits in.
+func fillBlocks(data []byte, blockSize int) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	size := blockSize * ((len(data) + blockSize - 1) / blockSize)
+	filled := make([]byte, size)
+	for i := range filled {
+		filled[i] = data[i%len(data)]
+	}
+	return filled
+}
+
+// bmpPassword returns the password as a null terminated big endian UTF-16 string, as used by the PKCS #12 KDF.
+func bmpPassword(password string) []byte {
+	var b []byte
+	for _, unit := range utf16.Encode([]rune(password)) {
+		b = append(b, byte(unit>>8), byte(unit))
+	}
+	return append(b, 0, 0)
+}
+
+func unpad(data []byte, blockSize int) ([]byte, error) {
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > blockSize || padding > len(data) {
+		return nil, errDecryption
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, errDecryption
+		}
+	}
+	return data[:len(data)-padding], nil
+}
@@ -0,0 +1,98 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ParsePEMKeyPair parses a PEM encoded private key and certificate chain, the signing certificate first.
+// The private key may be PKCS #8, PKCS #1 (RSA) or SEC 1 (EC) encoded, encrypted PEM keys are not supported.
+func ParsePEMKeyPair(keyPEM, certificatesPEM []byte) (crypto.Signer, []*x509.Certificate, error) {
+	key, err := parsePEMPrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var chain []*x509.Certificate
+	rest := certificatesPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		chain = append(chain, certificate)
+	}
+	if len(chain) == 0 {
+		return nil, nil, errors.New("no PEM certificate found")
+	}
+
+	if !publicKeysEqual(key.Public(), chain[0].PublicKey) {
+		return nil, nil, errors.New("the private key does not match the first certificate")
+	}
+	return key, chain, nil
+}
+
+func parsePEMPrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	rest := keyPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("no PEM private key found")
+		}
+
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, errors.New("encrypted PEM private keys are not supported")
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", block.Type, err)
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", key)
+		}
+		return signer, nil
+	}
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	switch key := a.(type) {
+	case *rsa.PublicKey:
+		return key.Equal(b)
+	case *ecdsa.PublicKey:
+		return key.Equal(b)
+	case ed25519.PublicKey:
+		return key.Equal(b)
+	default:
+		aDER, aErr := x509.MarshalPKIXPublicKey(a)
+		bDER, bErr := x509.MarshalPKIXPublicKey(b)
+		return aErr == nil && bErr == nil && bytes.Equal(aDER, bDER)
+	}
+}
@@ -0,0 +1,86 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePEMKeyPair(t *testing.T) {
+	// Given
+	key, certificate := givenKeyPair(t)
+	keyDER, err := x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certificatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+
+	// When
+	parsedKey, chain, err := ParsePEMKeyPair(keyPEM, certificatePEM)
+
+	// Then
+	require.NoError(t, err)
+	require.True(t, key.(*ecdsa.PrivateKey).Equal(parsedKey))
+	require.Equal(t, []*x509.Certificate{certificate}, chain)
+}
+
+func TestParsePEMKeyPair_KeyMismatch(t *testing.T) {
+	// Given
+	_, certificate := givenKeyPair(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(otherKey)})
+	certificatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+
+	// When
+	_, _, err = ParsePEMKeyPair(keyPEM, certificatePEM)
+
+	// Then
+	require.EqualError(t, err, "the private key does not match the first certificate")
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	// Given
+	key, certificate := givenKeyPair(t)
+
+	// When
+	data, err := EncodePKCS12(key, []*x509.Certificate{certificate}, "upload", "generated password")
+
+	// Then
+	require.NoError(t, err)
+	keystore, err := Parse(data, "generated password")
+	require.NoError(t, err)
+	require.Equal(t, TypePKCS12, keystore.Type)
+	require.Equal(t, []string{"upload"}, keystore.PrivateKeyAliases())
+	require.NoError(t, keystore.CheckKey("upload", "generated password"))
+	keystoreCertificate, err := keystore.Certificate("upload")
+	require.NoError(t, err)
+	require.Equal(t, certificate.Raw, keystoreCertificate.Raw)
+}
+
+func givenKeyPair(t *testing.T) (crypto.Signer, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Release"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return key, certificate
+}
@@ -0,0 +1,267 @@
+package keystore
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+)
+
+// PKCS #12 structures, based on: https://datatracker.ietf.org/doc/html/rfc7292
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+
+	oidFriendlyName = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+
+	macAlgorithms = map[string]func() hash.Hash{
+		"1.3.14.3.2.26":          sha1.New,
+		"2.16.840.1.101.3.4.2.1": sha256.New,
+		"2.16.840.1.101.3.4.2.2": sha512.New384,
+		"2.16.840.1.101.3.4.2.3": sha512.New,
+	}
+)
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"optional,tag:0"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"explicit,tag:0"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+func parsePKCS12(data []byte, storePassword string) (*Keystore, error) {
+	var p pfx
+	if rest, err := asn1.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, err)
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrUnsupportedFormat)
+	}
+	if !p.AuthSafe.ContentType.Equal(oidData) {
+		return nil, fmt.Errorf("unsupported PKCS #12 content type: %s", p.AuthSafe.ContentType)
+	}
+
+	var authSafeData []byte
+	if _, err := asn1.Unmarshal(p.AuthSafe.Content.Bytes, &authSafeData); err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS #12 content: %w", err)
+	}
+	if len(p.MacData.Mac.Digest) > 0 {
+		if err := verifyPKCS12MAC(p.MacData, authSafeData, storePassword); err != nil {
+			return nil, err
+		}
+	}
+
+	var authSafe []contentInfo
+	if _, err := asn1.Unmarshal(authSafeData, &authSafe); err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS #12 authenticated safe: %w", err)
+	}
+
+	var bags []safeBag
+	for _, info := range authSafe {
+		var safeContents []byte
+		switch {
+		case info.ContentType.Equal(oidData):
+			if _, err := asn1.Unmarshal(info.Content.Bytes, &safeContents); err != nil {
+				return nil, fmt.Errorf("failed to parse PKCS #12 safe contents: %w", err)
+			}
+		case info.ContentType.Equal(oidEncryptedData):
+			var encrypted encryptedData
+			if _, err := asn1.Unmarshal(info.Content.Bytes, &encrypted); err != nil {
+				return nil, fmt.Errorf("failed to parse PKCS #12 encrypted data: %w", err)
+			}
+			var err error
+			safeContents, err = decryptPBE(encrypted.EncryptedContentInfo.ContentEncryptionAlgorithm, storePassword, encrypted.EncryptedContentInfo.EncryptedContent)
+			if errors.Is(err, ErrUnsupportedAlgorithm) {
+				// Legacy keystores encrypt their certificates with RC2, the keys are listed in plain safe contents.
+				continue
+			}
+			if err != nil {
+				return nil, ErrWrongStorePassword
+			}
+		default:
+			continue
+		}
+
+		var contents []safeBag
+		if _, err := asn1.Unmarshal(safeContents, &contents); err != nil {
+			return nil, fmt.Errorf("failed to parse PKCS #12 safe bags: %w", err)
+		}
+		bags = append(bags, contents...)
+	}
+
+	return pkcs12Keystore(bags), nil
+}
+
+// pkcs12Keystore creates an entry for each key bag, and for each certificate bag not belonging to a key.
+// The keys' certificates are matched by their local key ID.
+func pkcs12Keystore(bags []safeBag) *Keystore {
+	keyIDs := map[string]bool{}
+	certificates := map[string][]byte{}
+	for _, bag := range bags {
+		switch {
+		case bag.ID.Equal(oidKeyBag) || bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+			keyIDs[bagLocalKeyID(bag)] = true
+		case bag.ID.Equal(oidCertBag):
+			if localKeyID := bagLocalKeyID(bag); localKeyID != "" {
+				certificates[localKeyID] = bagCertificate(bag)
+			}
+		}
+	}
+
+	keystore := &Keystore{Type: TypePKCS12}
+	for _, bag := range bags {
+		bag := bag
+		alias := bagFriendlyName(bag)
+		switch {
+		case bag.ID.Equal(oidKeyBag):
+			keystore.entries = append(keystore.entries, entry{alias: alias, certificate: certificates[bagLocalKeyID(bag)], decryptKey: func(string) error {
+				return nil
+			}})
+		case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+			keystore.entries = append(keystore.entries, entry{alias: alias, certificate: certificates[bagLocalKeyID(bag)], decryptKey: func(password string) error {
+				return decryptShroudedKey(bag.Value.Bytes, password)
+			}})
+		case bag.ID.Equal(oidCertBag):
+			if localKeyID := bagLocalKeyID(bag); (localKeyID == "" || !keyIDs[localKeyID]) && alias != "" {
+				keystore.entries = append(keystore.entries, entry{alias: alias, certificate: bagCertificate(bag)})
+			}
+		}
+	}
+	return keystore
+}
+
+// bagCertificate returns the DER encoded X.509 certificate of a certificate bag, or nil for other certificate types.
+func bagCertificate(bag safeBag) []byte {
+	var cert certBag
+	if _, err := asn1.Unmarshal(bag.Value.Bytes, &cert); err != nil || !cert.ID.Equal(oidX509CertificateBag) {
+		return nil
+	}
+	var der []byte
+	if _, err := asn1.Unmarshal(cert.Value.Bytes, &der); err != nil {
+		return nil
+	}
+	return der
+}
+
+func decryptShroudedKey(data []byte, password string) error {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(data, &info); err != nil {
+		return fmt.Errorf("failed to parse shrouded key: %w", err)
+	}
+
+	plain, err := decryptPBE(info.Algorithm, password, info.EncryptedData)
+	if errors.Is(err, ErrUnsupportedAlgorithm) {
+		return err
+	}
+	if err != nil {
+		return ErrWrongKeyPassword
+	}
+	// A wrong password may still result in valid padding
+	if _, err := x509.ParsePKCS8PrivateKey(plain); err != nil {
+		return ErrWrongKeyPassword
+	}
+	return nil
+}
+
+func verifyPKCS12MAC(mac macData, content []byte, password string) error {
+	newHash, ok := macAlgorithms[mac.Mac.Algorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("%w: PKCS #12 MAC %s", ErrUnsupportedAlgorithm, mac.Mac.Algorithm.Algorithm)
+	}
+
+	passwords := [][]byte{bmpPassword(password)}
+	if password == "" {
+		// Some implementations derive the MAC key of an empty password without the null terminator
+		passwords = append(passwords, nil)
+	}
+	for _, passwordBytes := range passwords {
+		key := pkcs12KDF(newHash, passwordBytes, mac.MacSalt, kdfIDMAC, mac.Iterations, newHash().Size())
+		h := hmac.New(newHash, key)
+		h.Write(content)
+		if hmac.Equal(h.Sum(nil), mac.Mac.Digest) {
+			return nil
+		}
+	}
+	return ErrWrongStorePassword
+}
+
+func bagFriendlyName(bag safeBag) string {
+	value := bagAttribute(bag, oidFriendlyName)
+	var bmp asn1.RawValue
+	if _, err := asn1.Unmarshal(value, &bmp); err != nil || len(bmp.Bytes)%2 != 0 {
+		return ""
+	}
+
+	units := make([]uint16, len(bmp.Bytes)/2)
+	for i := range units {
+		units[i] = uint16(bmp.Bytes[2*i])<<8 | uint16(bmp.Bytes[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+func bagLocalKeyID(bag safeBag) string {
+	var id []byte
+	if _, err := asn1.Unmarshal(bagAttribute(bag, oidLocalKeyID), &id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// bagAttribute returns the DER encoded first value of the bag's attribute.
+func bagAttribute(bag safeBag, id asn1.ObjectIdentifier) []byte {
+	for _, attribute := range bag.Attributes {
+		if attribute.ID.Equal(id) {
+			return attribute.Value.Bytes
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"unicode/utf16"
+)
+
+const pkcs12Iterations = 2048
+
+var (
+	oidSHA1               = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidX509CertificateBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+)
+
+// Structures for encoding, the explicitly tagged fields are wrapped by hand.
+type pfxOut struct {
+	Version  int
+	AuthSafe contentInfoOut
+	MacData  macData
+}
+
+type contentInfoOut struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type safeBagOut struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []attributeOut `asn1:"set"`
+}
+
+type attributeOut struct {
+	ID     asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type certBag struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// EncodePKCS12 creates a PKCS #12 keystore holding the private key and its certificate chain under the alias.
+// The key is encrypted with pbeWithSHAAnd3-KeyTripleDES-CBC and the store is protected by a SHA-1 MAC,
+// which are supported by all the Java versions running bundletool. The key password equals the store password.
+func EncodePKCS12(key crypto.PrivateKey, chain []*x509.Certificate, alias, password string) ([]byte, error) {
+	localKeyID := sha1.Sum(chain[0].Raw)
+	attributes, err := bagAttributes(alias, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := encryptPBEWithSHAAnd3KeyTripleDES(password, keyDER)
+	if err != nil {
+		return nil, err
+	}
+	keyBag := safeBagOut{ID: oidPKCS8ShroudedKeyBag, Value: explicitTag0(encryptedKey), Attributes: attributes}
+
+	var certBags []safeBagOut
+	for i, certificate := range chain {
+		certificateValue, err := asn1.Marshal(certificate.Raw)
+		if err != nil {
+			return nil, err
+		}
+		bag, err := asn1.Marshal(certBag{ID: oidX509CertificateBag, Value: explicitTag0(certificateValue)})
+		if err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, safeBagOut{ID: oidCertBag, Value: explicitTag0(bag)})
+		if i == 0 {
+			certBags[0].Attributes = attributes
+		}
+	}
+
+	var authSafe []contentInfoOut
+	for _, bags := range [][]safeBagOut{certBags, {keyBag}} {
+		safeContents, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		info, err := dataContentInfo(safeContents)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, info)
+	}
+	authSafeDER, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(sha1.New, bmpPassword(password), salt, kdfIDMAC, pkcs12Iterations, sha1.Size)
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(authSafeDER)
+
+	authSafeInfo, err := dataContentInfo(authSafeDER)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxOut{
+		Version:  3,
+		AuthSafe: authSafeInfo,
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+func encryptPBEWithSHAAnd3KeyTripleDES(password string, data []byte) ([]byte, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return nil, err
+	}
+
+	passwordBytes := bmpPassword(password)
+	key := pkcs12KDF(sha1.New, passwordBytes, salt, kdfIDKey, pkcs12Iterations, 24)
+	iv := pkcs12KDF(sha1.New, passwordBytes, salt, kdfIDIV, pkcs12Iterations, des.BlockSize)
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := des.BlockSize - len(data)%des.BlockSize
+	encrypted := append(append([]byte{}, data...), make([]byte, padding)...)
+	for i := len(data); i < len(encrypted); i++ {
+		encrypted[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTripleDESCBC, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+}
+
+// bagAttributes returns the friendlyName and localKeyId attributes linking the key and its certificate.
+func bagAttributes(alias string, localKeyID []byte) ([]attributeOut, error) {
+	var name []byte
+	for _, unit := range utf16.Encode([]rune(alias)) {
+		name = append(name, byte(unit>>8), byte(unit))
+	}
+	friendlyName, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: name})
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []attributeOut{
+		{ID: oidFriendlyName, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: friendlyName}},
+		{ID: oidLocalKeyID, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: keyID}},
+	}, nil
+}
+
+func dataContentInfo(data []byte) (contentInfoOut, error) {
+	content, err := asn1.Marshal(data)
+	if err != nil {
+		return contentInfoOut{}, err
+	}
+	return contentInfoOut{ContentType: oidData, Content: explicitTag0(content)}, nil
+}
+
+func explicitTag0(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
@@ -0,0 +1,87 @@
+package keystore
+
+import (
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The testdata keystores were generated with OpenSSL 3, the store and key password is "storepass":
+// - release.p12: default algorithms (PBES2 with AES-256-CBC, SHA-256 MAC)
+// - release-3des.p12: -keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1
+func TestOpen_PKCS12(t *testing.T) {
+	for _, pth := range []string{"testdata/release.p12", "testdata/release-3des.p12"} {
+		t.Run(pth, func(t *testing.T) {
+			// When
+			keystore, err := Open(pth, "storepass")
+
+			// Then
+			require.NoError(t, err)
+			require.Equal(t, TypePKCS12, keystore.Type)
+			require.Equal(t, []string{"release"}, keystore.PrivateKeyAliases())
+			require.NoError(t, keystore.CheckKey("release", "storepass"))
+			require.EqualError(t, keystore.CheckKey("release", "wrong"), "alias (release): private key password is incorrect")
+			certificate, err := keystore.Certificate("release")
+			require.NoError(t, err)
+			require.Equal(t, "CN=Release", certificate.Subject.String())
+		})
+	}
+}
+
+func TestOpen_PKCS12WrongStorePassword(t *testing.T) {
+	// When
+	_, err := Open("testdata/release.p12", "wrong")
+
+	// Then
+	require.Equal(t, ErrWrongStorePassword, err)
+}
+
+func TestParse_UnsupportedFormat(t *testing.T) {
+	// When
+	_, err := Parse([]byte("not a keystore"), "storepass")
+
+	// Then
+	require.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func Test_verifyPKCS12MAC_UnsupportedAlgorithm(t *testing.T) {
+	// Given: an HMAC-MD5 MAC
+	mac := macData{Mac: digestInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}}}}
+
+	// When
+	err := verifyPKCS12MAC(mac, []byte("content"), "storepass")
+
+	// Then
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func Test_decryptShroudedKey_UnsupportedAlgorithm(t *testing.T) {
+	// Given: a key encrypted with PBE-SHA1-RC2-40
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}},
+		EncryptedData: []byte("encrypted"),
+	})
+	require.NoError(t, err)
+
+	// When
+	err = decryptShroudedKey(info, "storepass")
+
+	// Then
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func Test_pkcs12KDF(t *testing.T) {
+	// Given: test vector from the Bouncy Castle PKCS #12 tests
+	password := bmpPassword("smeg")
+	salt := []byte{0x0a, 0x58, 0xcf, 0x64, 0x53, 0x0d, 0x82, 0x3f}
+
+	// When
+	key := pkcs12KDF(sha1.New, password, salt, kdfIDKey, 1, 24)
+
+	// Then
+	require.Equal(t, "8aaae6297b6cb04642ab5b077851284eb7128f1a2a7fbca3", hex.EncodeToString(key))
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
 	"github.com/bitrise-io/go-steputils/tools"
@@ -11,67 +18,777 @@ import (
 	logv2 "github.com/bitrise-io/go-utils/v2/log"
 	"github.com/bitrise-io/go-utils/v2/retryhttp"
 	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/apkexporter"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/apksignature"
 	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/bundletool"
 	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/filedownloader"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/keystore"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/redactor"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/workspace"
+)
+
+// Signing modes
+const (
+	// signingModeAuto signs with the keystore if it is fully configured, otherwise with the debug keystore.
+	signingModeAuto = "auto"
+	// signingModeRequireRelease fails if the keystore is not fully configured or the APK ends up debug signed.
+	signingModeRequireRelease = "require_release"
+	// signingModeDebug always signs with the debug keystore.
+	signingModeDebug = "debug"
 )
 
 // Config is defining the input arguments required by the Step.
 type Config struct {
-	DeployDir         string `env:"BITRISE_DEPLOY_DIR"`
-	AABPath           string `env:"aab_path,required"`
-	KeystoreURL       string `env:"keystore_url"`
-	KeystotePassword  string `env:"keystore_password"`
-	KeyAlias          string `env:"keystore_alias"`
-	KeyPassword       string `env:"private_key_password"`
-	BundletoolVersion string `env:"bundletool_version"`
+	DeployDir        string          `env:"BITRISE_DEPLOY_DIR"`
+	AABPath          string          `env:"aab_path"`
+	KeystoreURL      stepconf.Secret `env:"keystore_url"`
+	KeystotePassword stepconf.Secret `env:"keystore_password"`
+	KeyAlias         stepconf.Secret `env:"keystore_alias"`
+	KeyPassword      stepconf.Secret `env:"private_key_password"`
+
+	KeystoreBase64         stepconf.Secret `env:"keystore_base64"`
+	SigningKeyPEM          stepconf.Secret `env:"signing_key_pem"`
+	SigningCertificatePEM  string          `env:"signing_certificate_pem"`
+	KeystorePropertiesPath string          `env:"keystore_properties_path"`
+
+	KeystorePasswordSource string `env:"keystore_password_source,opt[literal,file,env,stdin,legacy]"`
+	KeyPasswordSource      string `env:"private_key_password_source,opt[literal,file,env,stdin,legacy]"`
+
+	AllowedSigners          string `env:"allowed_signer_sha256"`
+	SigningMode             string `env:"signing_mode,opt[auto,require_release,debug]"`
+	BundletoolVersion       string `env:"bundletool_version"`
+	BundletoolSHA256        string `env:"bundletool_sha256"`
+	BundletoolRequireSHA256 bool   `env:"bundletool_require_sha256,opt[yes,no]"`
+	BundletoolCache         string `env:"bundletool_cache_dir"`
+	BundletoolPath          string `env:"bundletool_path"`
+	BundletoolRelease       string `env:"bundletool_releases_url"`
+	BundletoolDownloadURL   string `env:"bundletool_download_url"`
+
+	JVMMaxHeap         string `env:"jvm_max_heap"`
+	JVMTmpDir          string `env:"jvm_tmpdir"`
+	JVMOptions         string `env:"jvm_options"`
+	RetryOnOutOfMemory bool   `env:"retry_on_out_of_memory,opt[yes,no]"`
+
+	AABPathList       string `env:"aab_path_list"`
+	ExportParallelism int    `env:"export_parallelism,range[1..16]"`
+	ReuseExistingAPK  bool   `env:"reuse_existing_apk,opt[yes,no]"`
+	APKNameTemplate   string `env:"apk_name_template"`
+
+	AABDiscovery        bool   `env:"aab_discovery,opt[yes,no]"`
+	AABDiscoveryPattern string `env:"aab_discovery_pattern"`
+	IncludeBuildTypes   string `env:"include_build_types"`
+	ExcludeBuildTypes   string `env:"exclude_build_types"`
+	IncludeFlavors      string `env:"include_flavors"`
+	ExcludeFlavors      string `env:"exclude_flavors"`
+
+	BuildMode    string `env:"build_mode,opt[universal,default,system,instant,archive]"`
+	Modules      string `env:"modules"`
+	OptimizeFor  string `env:"optimize_for"`
+	LocalTesting bool   `env:"local_testing,opt[yes,no]"`
+
+	DeviceSpecPath string `env:"device_spec_path"`
 }
 
+// secrets masks the keystore URL, alias and passwords in the log.
+var secrets = redactor.New()
+
+// stepWorkspace holds the Step's temporary files, it is removed on exit.
+var stepWorkspace *workspace.Workspace
+
 func main() {
+	log.SetOutWriter(secrets.Writer(os.Stdout))
+
+	ws, err := workspace.New("export-universal-apk")
+	if err != nil {
+		failf("Failed to create workspace: %s \n", err)
+	}
+	stepWorkspace = ws
+	ws.CleanupOnSignal()
+
 	var config Config
 	if err := stepconf.Parse(&config); err != nil {
 		failf("Error: %s \n", err)
 	}
+	registerSecrets(config)
+	if config.KeystorePropertiesPath != "" {
+		if config, err = applyKeystoreProperties(config); err != nil {
+			failf("Failed to read keystore properties: %s \n", err)
+		}
+		// The keystore path, alias and passwords may come from keystore.properties
+		registerSecrets(config)
+	}
 	stepconf.Print(config)
 	fmt.Println()
 
+	allowedSigners, err := apksignature.ParseFingerprints(config.AllowedSigners)
+	if err != nil {
+		failf("Invalid allowed signer fingerprints: %s \n", err)
+	}
+	aabPaths, err := parseAABPaths(config)
+	if err != nil {
+		failf("Invalid bundle paths: %s \n", err)
+	}
+	var apkNameTemplate *apkexporter.APKNameTemplate
+	if strings.TrimSpace(config.APKNameTemplate) != "" {
+		if apkNameTemplate, err = apkexporter.ParseAPKNameTemplate(config.APKNameTemplate); err != nil {
+			failf("Invalid APK name template: %s \n", err)
+		}
+	}
+
 	httpClient := retryhttp.NewClient(logv2.NewLogger())
-	bundletoolTool, err := bundletool.New(config.BundletoolVersion, filedownloader.New(httpClient), bundletool.GithubReleaseBaseURL)
+	bundletoolTool, err := initBundletool(config, httpClient, filedownloader.New(httpClient))
 	if err != nil {
 		failf("Failed to initialize bundletool: %s \n", err)
 	}
 	log.Infof("bundletool path created at: %s", bundletoolTool.Path())
 
-	exporter := apkexporter.New(bundletoolTool, filedownloader.New(httpClient))
-	keystoreCfg := parseKeystoreConfig(config)
-	apkPath, err := exporter.ExportUniversalAPK(config.AABPath, config.DeployDir, keystoreCfg)
+	checkedTool, err := checkJava(*bundletoolTool)
+	if err != nil {
+		failf("Incompatible Java: %s \n", err)
+	}
+	bundletoolTool = &checkedTool
+
+	if bundletoolTool.Version() != "" {
+		if err := tools.ExportEnvironmentWithEnvman("BUNDLETOOL_VERSION", bundletoolTool.Version()); err != nil {
+			failf("Failed to export BUNDLETOOL_VERSION, error: %s \n", err)
+		}
+	}
+
+	configuredTool := bundletoolTool.WithJVMOptions(bundletool.JVMOptions{
+		MaxHeap: config.JVMMaxHeap,
+		TmpDir:  config.JVMTmpDir,
+		Args:    strings.Fields(config.JVMOptions),
+	})
+	bundletoolTool = &configuredTool
+
+	exporter := apkexporter.New(bundletoolTool, filedownloader.New(httpClient)).WithWorkspace(ws).WithSecrets(secrets)
+	if config.RetryOnOutOfMemory {
+		exporter = exporter.WithOutOfMemoryRetry()
+	}
+	if config.ReuseExistingAPK {
+		exporter = exporter.WithReuseExisting()
+	}
+	if apkNameTemplate != nil {
+		exporter = exporter.WithAPKNameTemplate(apkNameTemplate)
+	}
+	signingDir, err := ws.SensitiveDir("signing")
+	if err != nil {
+		failf("Failed to create temporary directory: %s \n", err)
+	}
+	keystoreCfg, err := parseKeystoreConfig(config, bufio.NewReader(os.Stdin), signingDir)
+	if err != nil {
+		failf("Invalid signing configuration: %s \n", err)
+	}
+	if keystoreCfg != nil {
+		registerPasswordSecrets(*keystoreCfg)
+	}
+
+	mode, err := bundletool.ParseMode(config.BuildMode)
+	if err != nil {
+		failf("Invalid build mode: %s \n", err)
+	}
+	if len(aabPaths) > 1 && (config.DeviceSpecPath != "" || mode != bundletool.ModeUniversal) {
+		failf("Exporting multiple bundles is only supported in universal build mode without a device spec, set aab_path_list to an empty value to export aab_path only \n")
+	}
+	aabPath := aabPaths[0]
+
+	if config.DeviceSpecPath != "" {
+		spec, err := bundletool.ReadDeviceSpec(config.DeviceSpecPath)
+		if err != nil {
+			failf("Invalid device spec: %s \n", err)
+		}
+		log.Infof("Extracting APKs for device spec: SDK %d, ABIs: %s, screen density: %d, locales: %s",
+			spec.SDKVersion, strings.Join(spec.SupportedABIs, ","), spec.ScreenDensity, strings.Join(spec.SupportedLocales, ","))
+
+		apkPaths, err := exporter.ExportDeviceAPKs(aabPath, config.DeployDir, config.DeviceSpecPath, keystoreCfg)
+		if err != nil {
+			failf("Failed to export device APKs, error: %s \n", err)
+		}
+
+		if err := verifyDeviceAPKs(apkPaths, allowedSigners, config.SigningMode); err != nil {
+			failf("Failed to verify APK signature, error: %s \n", err)
+		}
+
+		if err = tools.ExportEnvironmentWithEnvman("BITRISE_APK_PATH_LIST", strings.Join(apkPaths, "|")); err != nil {
+			failf("Failed to export BITRISE_APK_PATH_LIST, error: %s \n", err)
+		}
+
+		log.Donef("Success! Device APKs exported to: %s", strings.Join(apkPaths, ", "))
+		exit(0)
+	}
+
+	if mode != bundletool.ModeUniversal {
+		apksPath, err := exporter.ExportAPKSet(aabPath, config.DeployDir, parseBuildAPKsOptions(config, mode), keystoreCfg)
+		if err != nil {
+			failf("Failed to export APK set, error: %s \n", err)
+		}
+
+		verificationDir, err := ws.Dir("apk_set_verification")
+		if err != nil {
+			failf("Failed to create temporary directory: %s \n", err)
+		}
+		if err := verifyAPKSet(apksPath, verificationDir, allowedSigners, config.SigningMode); err != nil {
+			failf("Failed to verify APK signature, error: %s \n", err)
+		}
+
+		if err = tools.ExportEnvironmentWithEnvman("BITRISE_APKS_PATH", apksPath); err != nil {
+			failf("Failed to export BITRISE_APKS_PATH, error: %s \n", err)
+		}
+
+		log.Donef("Success! APK set exported to: %s", apksPath)
+		exit(0)
+	}
+
+	results, err := exporter.ExportUniversalAPKs(aabPaths, config.DeployDir, keystoreCfg, config.ExportParallelism)
 	if err != nil {
 		failf("Failed to export apk, error: %s \n", err)
 	}
 
-	if err = tools.ExportEnvironmentWithEnvman("BITRISE_APK_PATH", apkPath); err != nil {
-		failf("Failed to export BITRISE_APK_PATH, error: %s \n", err)
+	var apkPaths []string
+	signerFingerprint := ""
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		signature, err := verifyAPKSignature(result.APKPath, allowedSigners, config.SigningMode)
+		if err != nil {
+			results[i].Err = fmt.Errorf("failed to verify APK signature: %w", err)
+			removeRejectedAPK(result.APKPath)
+			continue
+		}
+		apkPaths = append(apkPaths, result.APKPath)
+		if signerFingerprint == "" {
+			signerFingerprint = signature.Signers[0].SHA256Fingerprint
+		}
 	}
+	failed := logExportSummary(results)
 
-	log.Donef("Success! APK exported to: %s", apkPath)
-	os.Exit(0)
+	if len(apkPaths) > 0 {
+		if err = tools.ExportEnvironmentWithEnvman("BITRISE_APK_PATH", apkPaths[len(apkPaths)-1]); err != nil {
+			failf("Failed to export BITRISE_APK_PATH, error: %s \n", err)
+		}
+		if err = tools.ExportEnvironmentWithEnvman("BITRISE_APK_PATH_LIST", strings.Join(apkPaths, "|")); err != nil {
+			failf("Failed to export BITRISE_APK_PATH_LIST, error: %s \n", err)
+		}
+		if err = tools.ExportEnvironmentWithEnvman("BITRISE_APK_SIGNER_SHA256", signerFingerprint); err != nil {
+			failf("Failed to export BITRISE_APK_SIGNER_SHA256, error: %s \n", err)
+		}
+	}
+	if failed > 0 {
+		failf("Failed to export %d of %d universal APK(s) \n", failed, len(results))
+	}
+
+	log.Donef("Success! APK exported to: %s", strings.Join(apkPaths, ", "))
+	exit(0)
+}
+
+// checkJava checks that bundletool can run. A launcher selects its own Java runtime,
+// a jar is run with the Java runtime found in JAVA_HOME or on the PATH.
+func checkJava(tool bundletool.Tool) (bundletool.Tool, error) {
+	if tool.IsLauncher() {
+		return tool.CheckLauncher()
+	}
+
+	java, err := bundletool.FindJava()
+	if err != nil {
+		return bundletool.Tool{}, err
+	}
+	log.Infof("Using Java %d at: %s", java.MajorVersion, java.Path)
+	return tool.CheckJava(java)
 }
 
-func parseKeystoreConfig(config Config) *bundletool.KeystoreConfig {
-	if config.KeystoreURL == "" ||
-		config.KeystotePassword == "" ||
-		config.KeyAlias == "" ||
-		config.KeyPassword == "" {
-		return nil
+// parseAABPaths returns the bundles to export. If discovery is enabled the bundles are discovered,
+// otherwise aab_path_list takes precedence over aab_path.
+func parseAABPaths(config Config) ([]string, error) {
+	if config.AABDiscovery {
+		return discoverAABPaths(config)
+	}
+
+	aabPaths := splitList(config.AABPathList, "|")
+	if len(aabPaths) == 0 && strings.TrimSpace(config.AABPath) != "" {
+		aabPaths = []string{strings.TrimSpace(config.AABPath)}
+	}
+
+	if len(aabPaths) == 0 {
+		return nil, errors.New("neither aab_path nor aab_path_list is set")
+	}
+	return aabPaths, nil
+}
+
+// discoverAABPaths finds the bundles in the deploy dir or matching the discovery pattern,
+// and returns a bundle for each build variant selected by the build type and flavor filters.
+func discoverAABPaths(config Config) ([]string, error) {
+	pattern := strings.TrimSpace(config.AABDiscoveryPattern)
+	found, err := apkexporter.FindAABs(config.DeployDir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if pattern == "" {
+		pattern = config.DeployDir
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no bundle found in: %s", pattern)
+	}
+
+	selected := apkexporter.SelectAABs(found, apkexporter.VariantFilter{
+		IncludeBuildTypes: splitList(config.IncludeBuildTypes, ","),
+		ExcludeBuildTypes: splitList(config.ExcludeBuildTypes, ","),
+		IncludeFlavours:   splitList(config.IncludeFlavors, ","),
+		ExcludeFlavours:   splitList(config.ExcludeFlavors, ","),
+	})
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("none of the %d bundle(s) found in %s match the build type and flavor filters", len(found), pattern)
+	}
+
+	log.Infof("Discovered %d bundle(s) in %s, selected variants:", len(found), pattern)
+	var aabPaths []string
+	for _, aab := range selected {
+		log.Printf("- %s: %s", aab.Variant, aab.AABPath)
+		aabPaths = append(aabPaths, aab.AABPath)
+	}
+	return aabPaths, nil
+}
+
+// splitList splits a separated list input, dropping the empty items.
+func splitList(s, separator string) []string {
+	var items []string
+	for _, item := range strings.Split(s, separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// logExportSummary logs the outcome of each bundle's export and returns the number of failed exports.
+func logExportSummary(results []apkexporter.UniversalAPKResult) int {
+	failed := 0
+	fmt.Println()
+	log.Infof("Export summary:")
+	for _, result := range results {
+		duration := result.Duration.Round(time.Second)
+		if result.Err != nil {
+			failed++
+			log.Errorf("- %s: failed after %s: %s", filepath.Base(result.AABPath), duration, result.Err)
+			continue
+		}
+		if result.Reused {
+			log.Donef("- %s: %s (reused)", filepath.Base(result.AABPath), filepath.Base(result.APKPath))
+			continue
+		}
+		log.Donef("- %s: %s (%s)", filepath.Base(result.AABPath), filepath.Base(result.APKPath), duration)
+	}
+	fmt.Println()
+	return failed
+}
+
+// verifyAPKSignature verifies the APK's signatures and logs the signer certificates.
+// If allowedSigners is not empty, the APK has to be signed only by the listed certificates.
+// In require_release signing mode the APK must not be signed with the debug certificate.
+func verifyAPKSignature(apkPath string, allowedSigners []string, signingMode string) (apksignature.Result, error) {
+	result, err := apksignature.Verify(apkPath)
+	if err != nil {
+		return apksignature.Result{}, err
+	}
+
+	var schemes []string
+	for _, scheme := range result.Schemes {
+		schemes = append(schemes, string(scheme))
+	}
+	log.Infof("APK signature verified (%s), schemes: %s", filepath.Base(apkPath), strings.Join(schemes, ", "))
+	for _, signer := range result.Signers {
+		log.Printf("- Signer: %s", signer.Subject)
+		log.Printf("  SHA-256 fingerprint: %s", signer.SHA256Fingerprint)
+		log.Printf("  Valid from %s until %s", signer.NotBefore.Format(time.RFC3339), signer.NotAfter.Format(time.RFC3339))
+	}
+	if err := checkSigners(result, allowedSigners, signingMode); err != nil {
+		return apksignature.Result{}, err
+	}
+	return result, nil
+}
+
+// checkSigners applies the signing mode and the allowed signer fingerprints to a verified APK signature,
+// the same way in every build mode.
+func checkSigners(result apksignature.Result, allowedSigners []string, signingMode string) error {
+	if result.IsDebugSigned() {
+		if signingMode == signingModeRequireRelease {
+			return fmt.Errorf("signing mode is %s, but the APK is signed with the Android debug certificate", signingModeRequireRelease)
+		}
+		log.Warnf("The APK is signed with the Android debug certificate, it can not be published to Google Play.")
+	}
+
+	if len(allowedSigners) > 0 {
+		if err := result.CheckSigners(allowedSigners); err != nil {
+			return err
+		}
+		log.Donef("The APK signer matches the allowed certificate fingerprints")
+	}
+	return nil
+}
+
+// verifyDeviceAPKs verifies the signature of each APK extracted for a device. If any of them fails the verification,
+// all of them are removed, as the device can only install the complete set.
+func verifyDeviceAPKs(apkPaths, allowedSigners []string, signingMode string) error {
+	for _, apkPath := range apkPaths {
+		if _, err := verifyAPKSignature(apkPath, allowedSigners, signingMode); err != nil {
+			for _, pth := range apkPaths {
+				removeRejectedAPK(pth)
+			}
+			return fmt.Errorf("%s: %w", filepath.Base(apkPath), err)
+		}
+	}
+	return nil
+}
+
+// verifyAPKSet extracts the APKs of the APK set archive into tmpDir and verifies the signature of each of them.
+// If any of them fails the verification, the archive is removed.
+func verifyAPKSet(apksPath, tmpDir string, allowedSigners []string, signingMode string) error {
+	apkPaths, err := apkexporter.ExtractArchiveAPKs(apksPath, tmpDir)
+	if err == nil && len(apkPaths) == 0 {
+		err = errors.New("no APK found in the APK set")
+	}
+	if err != nil {
+		removeRejectedAPK(apksPath)
+		return err
+	}
+
+	for _, apkPath := range apkPaths {
+		if _, err := verifyAPKSignature(apkPath, allowedSigners, signingMode); err != nil {
+			removeRejectedAPK(apksPath)
+			name, relErr := filepath.Rel(tmpDir, apkPath)
+			if relErr != nil {
+				name = filepath.Base(apkPath)
+			}
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	log.Donef("Verified the signature of %d APK(s) in %s", len(apkPaths), filepath.Base(apksPath))
+	return nil
+}
+
+// removeRejectedAPK removes an APK failing the signature verification from the deploy dir, so that it is not deployed.
+func removeRejectedAPK(apkPath string) {
+	if err := os.Remove(apkPath); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to remove the rejected APK (%s): %s", apkPath, err)
+		return
+	}
+	log.Warnf("Removed the rejected APK: %s", apkPath)
+}
+
+// initBundletool returns the preinstalled bundletool if configured, otherwise the cached or downloaded one.
+func initBundletool(config Config, client bundletool.HTTPClient, downloader bundletool.FileDownloader) (*bundletool.Tool, error) {
+	if config.BundletoolPath != "" {
+		log.Infof("Using preinstalled bundletool: %s", config.BundletoolPath)
+		return bundletool.NewLocal(config.BundletoolPath)
+	}
+
+	cacheDir := config.BundletoolCache
+	if cacheDir == "" {
+		var err error
+		if cacheDir, err = bundletool.DefaultCacheDir(); err != nil {
+			return nil, fmt.Errorf("failed to determine bundletool cache directory: %w", err)
+		}
+	}
+	releasesURL := config.BundletoolRelease
+	if releasesURL == "" {
+		releasesURL = bundletool.GithubReleasesAPIURL
+	}
+	version, err := bundletool.ResolveVersion(config.BundletoolVersion, client, releasesURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve bundletool version (%s): %w", config.BundletoolVersion, err)
+	}
+	log.Infof("Resolved bundletool version: %s", version)
+
+	cache := bundletool.NewCache(cacheDir)
+	if config.BundletoolRequireSHA256 {
+		cache = cache.WithRequiredChecksum()
+	}
+	downloadURL := strings.TrimSpace(config.BundletoolDownloadURL)
+	if downloadURL == "" {
+		downloadURL = bundletool.GithubReleaseBaseURL
+	}
+	return bundletool.NewCached(version, config.BundletoolSHA256, cache, downloader, downloadURL)
+}
+
+func parseBuildAPKsOptions(config Config, mode bundletool.Mode) bundletool.BuildAPKsOptions {
+	return bundletool.BuildAPKsOptions{
+		Mode:         mode,
+		Modules:      splitList(config.Modules, ","),
+		OptimizeFor:  strings.TrimSpace(config.OptimizeFor),
+		LocalTesting: config.LocalTesting,
+	}
+}
+
+// parseKeystoreConfig returns the release signing configuration, or nil if the APK should be debug signed.
+// The passwords, the decoded base64 keystore and the keystore converted from PEM are written to signingDir,
+// stdin password sources are read from stdin.
+func parseKeystoreConfig(config Config, stdin *bufio.Reader, signingDir string) (*bundletool.KeystoreConfig, error) {
+	if config.SigningMode == signingModeDebug {
+		log.Infof("Signing mode is %s, the APK is signed with the debug keystore", signingModeDebug)
+		return nil, nil
+	}
+
+	keystoreURL := strings.TrimSpace(string(config.KeystoreURL))
+	keystoreBase64 := strings.TrimSpace(string(config.KeystoreBase64))
+	usePEM := strings.TrimSpace(string(config.SigningKeyPEM)) != "" || strings.TrimSpace(config.SigningCertificatePEM) != ""
+	sources := 0
+	for _, set := range []bool{keystoreURL != "", keystoreBase64 != "", usePEM} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, errors.New("only one of keystore_url, keystore_base64 and signing_key_pem can be set")
+	}
+	if usePEM {
+		return pemKeystoreConfig(config, stdin, signingDir)
+	}
+
+	keystorePassword, err := parsePasswordSource(config.KeystorePasswordSource, string(config.KeystotePassword))
+	if err != nil {
+		return nil, fmt.Errorf("keystore_password_source: %w", err)
+	}
+	keyPassword, err := parsePasswordSource(config.KeyPasswordSource, string(config.KeyPassword))
+	if err != nil {
+		return nil, fmt.Errorf("private_key_password_source: %w", err)
+	}
+	warnPasswordArgForm("keystore_password", keystorePassword)
+	warnPasswordArgForm("private_key_password", keyPassword)
+
+	// The alias is detected from the keystore if it has a single private key
+	var missing []string
+	if keystoreURL == "" && keystoreBase64 == "" {
+		missing = append(missing, "keystore_url")
+	}
+	if !keystorePassword.IsSet() {
+		missing = append(missing, "keystore_password")
+	}
+	if !keyPassword.IsSet() {
+		missing = append(missing, "private_key_password")
+	}
+
+	if len(missing) > 0 {
+		if config.SigningMode == signingModeRequireRelease {
+			return nil, fmt.Errorf("signing mode is %s, but the following inputs are not set: %s", signingModeRequireRelease, strings.Join(missing, ", "))
+		}
+		if len(missing) == 3 && config.KeyAlias == "" {
+			log.Infof("No keystore configured, the APK is signed with the debug keystore")
+		} else {
+			log.Warnf("Keystore configuration is incomplete (missing: %s), the APK is signed with the debug keystore", strings.Join(missing, ", "))
+		}
+		return nil, nil
+	}
+
+	if keystoreURL == "" {
+		pth, err := writeBase64Keystore(keystoreBase64, signingDir)
+		if err != nil {
+			return nil, fmt.Errorf("keystore_base64: %w", err)
+		}
+		keystoreURL = "file://" + pth
+	}
+
+	keystorePasswordArg, err := keystorePassword.Arg(stdin, signingDir, "keystore_password")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore password: %w", err)
+	}
+	keyPasswordArg, err := keyPassword.Arg(stdin, signingDir, "private_key_password")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key password: %w", err)
 	}
 
 	return &bundletool.KeystoreConfig{
-		Path:               strings.TrimSpace(config.KeystoreURL),
-		KeystorePassword:   config.KeystotePassword,
-		SigningKeyAlias:    config.KeyAlias,
-		SigningKeyPassword: config.KeyPassword}
+		Path:               keystoreURL,
+		KeystorePassword:   keystorePasswordArg,
+		SigningKeyAlias:    strings.TrimSpace(string(config.KeyAlias)),
+		SigningKeyPassword: keyPasswordArg}, nil
+}
+
+// writeBase64Keystore decodes the base64 encoded keystore into signingDir.
+func writeBase64Keystore(encoded, signingDir string) (string, error) {
+	// Line breaks are allowed, as added by `base64` without `-w 0`
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return "", fmt.Errorf("invalid base64: %w", err)
+	}
+
+	pth := filepath.Join(signingDir, "keystore")
+	if err := workspace.WriteSensitiveFile(pth, data); err != nil {
+		return "", err
+	}
+	return pth, nil
+}
+
+// pemKeystoreConfig converts the PEM private key and certificate chain to a PKCS #12 keystore in signingDir.
+// The keystore is protected with a generated password, the alias defaults to key0.
+func pemKeystoreConfig(config Config, stdin *bufio.Reader, signingDir string) (*bundletool.KeystoreConfig, error) {
+	var missing []string
+	if strings.TrimSpace(string(config.SigningKeyPEM)) == "" {
+		missing = append(missing, "signing_key_pem")
+	}
+	if strings.TrimSpace(config.SigningCertificatePEM) == "" {
+		missing = append(missing, "signing_certificate_pem")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the following inputs are not set: %s", strings.Join(missing, ", "))
+	}
+
+	keyPEM, err := readPEMInput(string(config.SigningKeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("signing_key_pem: %w", err)
+	}
+	certificatesPEM, err := readPEMInput(config.SigningCertificatePEM)
+	if err != nil {
+		return nil, fmt.Errorf("signing_certificate_pem: %w", err)
+	}
+	key, chain, err := keystore.ParsePEMKeyPair(keyPEM, certificatesPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	alias := strings.TrimSpace(string(config.KeyAlias))
+	if alias == "" {
+		alias = "key0"
+	}
+	passwordBytes := make([]byte, 24)
+	if _, err := rand.Read(passwordBytes); err != nil {
+		return nil, err
+	}
+	password := hex.EncodeToString(passwordBytes)
+
+	data, err := keystore.EncodePKCS12(key, chain, alias, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PKCS #12 keystore: %w", err)
+	}
+	pth := filepath.Join(signingDir, "keystore.p12")
+	if err := workspace.WriteSensitiveFile(pth, data); err != nil {
+		return nil, err
+	}
+	log.Infof("Converted the PEM private key and %d certificate(s) to a PKCS #12 keystore", len(chain))
+
+	passwordArg, err := bundletool.PasswordSource{Kind: bundletool.PasswordLiteral, Value: password}.Arg(stdin, signingDir, "keystore_password")
+	if err != nil {
+		return nil, err
+	}
+
+	return &bundletool.KeystoreConfig{
+		Path:               "file://" + pth,
+		KeystorePassword:   passwordArg,
+		SigningKeyAlias:    alias,
+		SigningKeyPassword: passwordArg}, nil
+}
+
+// readPEMInput returns the input if it holds PEM content, otherwise reads the file it points to.
+func readPEMInput(value string) ([]byte, error) {
+	if strings.Contains(value, "-----BEGIN ") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(strings.TrimSpace(value))
+}
+
+// warnPasswordArgForm warns if a literal password looks like bundletool's pass: or file: form,
+// which earlier versions of the Step passed to bundletool as is. The password is used unchanged.
+func warnPasswordArgForm(input string, source bundletool.PasswordSource) {
+	if source.Kind != bundletool.PasswordLiteral || !bundletool.IsPasswordArg(source.Value) {
+		return
+	}
+	log.Warnf("The %s input starts with pass: or file:, it is used as the password as is.", input)
+	log.Warnf("If it is in Bundletool's pass: or file: form, set %s_source to legacy.", input)
+}
+
+// applyKeystoreProperties fills the signing inputs not set explicitly from the keystore.properties file.
+func applyKeystoreProperties(config Config) (Config, error) {
+	properties, err := bundletool.ReadKeystoreProperties(config.KeystorePropertiesPath)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var used []string
+	keystoreSet := strings.TrimSpace(string(config.KeystoreURL)) != "" || strings.TrimSpace(string(config.KeystoreBase64)) != "" ||
+		strings.TrimSpace(string(config.SigningKeyPEM)) != "" || strings.TrimSpace(config.SigningCertificatePEM) != ""
+	if !keystoreSet && properties.Path != "" {
+		config.KeystoreURL = stepconf.Secret(properties.Path)
+		used = append(used, "storeFile")
+	}
+	if properties.KeystorePassword != "" && !passwordInputSet(config.KeystorePasswordSource, string(config.KeystotePassword)) {
+		password, err := bundletool.PasswordFromArg(properties.KeystorePassword)
+		if err != nil {
+			return Config{}, err
+		}
+		config.KeystotePassword = stepconf.Secret(password)
+		config.KeystorePasswordSource = string(bundletool.PasswordLiteral)
+		used = append(used, "storePassword")
+	}
+	if strings.TrimSpace(string(config.KeyAlias)) == "" && properties.SigningKeyAlias != "" {
+		config.KeyAlias = stepconf.Secret(properties.SigningKeyAlias)
+		used = append(used, "keyAlias")
+	}
+	if properties.SigningKeyPassword != "" && !passwordInputSet(config.KeyPasswordSource, string(config.KeyPassword)) {
+		password, err := bundletool.PasswordFromArg(properties.SigningKeyPassword)
+		if err != nil {
+			return Config{}, err
+		}
+		config.KeyPassword = stepconf.Secret(password)
+		config.KeyPasswordSource = string(bundletool.PasswordLiteral)
+		used = append(used, "keyPassword")
+	}
+
+	if len(used) > 0 {
+		log.Infof("Using %s from %s", strings.Join(used, ", "), config.KeystorePropertiesPath)
+	} else {
+		log.Infof("All signing inputs are set explicitly, %s is not used", config.KeystorePropertiesPath)
+	}
+	return config, nil
+}
+
+// passwordInputSet tells if a password input is set explicitly. An invalid source counts as set, so that it is reported.
+func passwordInputSet(kind, value string) bool {
+	source, err := parsePasswordSource(kind, value)
+	return err != nil || source.IsSet()
+}
+
+func parsePasswordSource(kind, value string) (bundletool.PasswordSource, error) {
+	sourceKind, err := bundletool.ParsePasswordSourceKind(kind)
+	if err != nil {
+		return bundletool.PasswordSource{}, err
+	}
+	if sourceKind != bundletool.PasswordLiteral && sourceKind != bundletool.PasswordLegacy {
+		value = strings.TrimSpace(value)
+	}
+	return bundletool.PasswordSource{Kind: sourceKind, Value: value}, nil
+}
+
+// registerSecrets masks the keystore inputs in the log.
+// Password inputs referring to a file or environment variable are masked too, the passwords are registered once read.
+func registerSecrets(config Config) {
+	secrets.Add(
+		string(config.KeystotePassword),
+		string(config.KeyPassword),
+		strings.TrimSpace(string(config.KeyAlias)),
+		strings.TrimSpace(string(config.KeystoreBase64)),
+		strings.TrimSpace(string(config.SigningKeyPEM)),
+	)
+	secrets.AddURL(strings.TrimSpace(string(config.KeystoreURL)))
+}
+
+// registerPasswordSecrets masks the passwords read from their sources.
+func registerPasswordSecrets(keystoreConfig bundletool.KeystoreConfig) {
+	for _, arg := range []string{keystoreConfig.KeystorePassword, keystoreConfig.SigningKeyPassword} {
+		if password, err := bundletool.PasswordFromArg(arg); err == nil {
+			secrets.Add(password)
+		}
+	}
 }
 
 func failf(s string, a ...interface{}) {
 	log.Errorf(s, a...)
-	os.Exit(1)
+	exit(1)
+}
+
+// exit removes the workspace, including the downloaded keystore and password files, then exits.
+func exit(code int) {
+	if stepWorkspace != nil {
+		if err := stepWorkspace.Cleanup(); err != nil {
+			log.Warnf("%s", err)
+		}
+	}
+	os.Exit(code)
 }
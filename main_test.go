@@ -1,36 +1,339 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/bitrise-io/go-steputils/stepconf"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/apksignature"
 	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/bundletool"
+	"github.com/bitrise-steplib/bitrise-step-export-universal-apk/keystore"
 	"github.com/stretchr/testify/require"
 )
 
 const (
 	KeystoreURL      = "/path/to/keystore.keystore"
-	KeystotePassword = "pass:unbreakable"
+	KeystotePassword = "unbreakable"
 	KeyAlias         = "muchalias"
-	KeyPassword      = "pass:12345678"
+	KeyPassword      = "12345678"
 )
 
 func Test_parseKeystoreConfig(t *testing.T) {
-	expectedKeystoreConfig := givenKeystoreConfig()
+	passwordDir := t.TempDir()
+	expectedKeystoreConfig := givenKeystoreConfig(passwordDir)
 
-	actualKeystoreConfig := parseKeystoreConfig(givenConfig())
+	actualKeystoreConfig, err := parseKeystoreConfig(givenConfig(), givenStdin(""), passwordDir)
 
+	require.NoError(t, err)
 	require.Equal(t, expectedKeystoreConfig, actualKeystoreConfig)
+	assertFileContent(t, filepath.Join(passwordDir, "keystore_password"), KeystotePassword)
+	assertFileContent(t, filepath.Join(passwordDir, "private_key_password"), KeyPassword)
+}
+
+func Test_parseKeystoreConfig_passwordSources(t *testing.T) {
+	passwordDir := t.TempDir()
+	t.Setenv("KEY_PASSWORD", "key password")
+	config := givenConfig()
+	config.KeystotePassword = ""
+	config.KeystorePasswordSource = "stdin"
+	config.KeyPassword = "KEY_PASSWORD"
+	config.KeyPasswordSource = "env"
+
+	actualKeystoreConfig, err := parseKeystoreConfig(config, givenStdin("store password\n"), passwordDir)
+
+	require.NoError(t, err)
+	require.Equal(t, givenKeystoreConfig(passwordDir), actualKeystoreConfig)
+	assertFileContent(t, filepath.Join(passwordDir, "keystore_password"), "store password")
+	assertFileContent(t, filepath.Join(passwordDir, "private_key_password"), "key password")
 }
 
 func Test_parseKeystoreConfig_missingRequiredParam(t *testing.T) {
 	config := givenConfig()
 	config.KeystoreURL = ""
 
-	parsedKeystoreConfig := parseKeystoreConfig(config)
+	parsedKeystoreConfig, err := parseKeystoreConfig(config, givenStdin(""), t.TempDir())
+
+	require.NoError(t, err)
+	require.Nil(t, parsedKeystoreConfig)
+}
+
+func Test_parseKeystoreConfig_requireRelease(t *testing.T) {
+	config := givenConfig()
+	config.SigningMode = signingModeRequireRelease
+	config.KeystoreURL = ""
+	config.KeyPassword = ""
+
+	parsedKeystoreConfig, err := parseKeystoreConfig(config, givenStdin(""), t.TempDir())
+
+	require.EqualError(t, err, "signing mode is require_release, but the following inputs are not set: keystore_url, private_key_password")
+	require.Nil(t, parsedKeystoreConfig)
+}
+
+func Test_parseKeystoreConfig_debug(t *testing.T) {
+	config := givenConfig()
+	config.SigningMode = signingModeDebug
+
+	parsedKeystoreConfig, err := parseKeystoreConfig(config, givenStdin(""), t.TempDir())
+
+	require.NoError(t, err)
+	require.Nil(t, parsedKeystoreConfig)
+}
+
+func Test_parseKeystoreConfig_base64Keystore(t *testing.T) {
+	signingDir := t.TempDir()
+	config := givenConfig()
+	config.KeystoreURL = ""
+	config.KeystoreBase64 = "a2V5\nc3RvcmU=\n"
+
+	actualKeystoreConfig, err := parseKeystoreConfig(config, givenStdin(""), signingDir)
+
+	require.NoError(t, err)
+	expectedKeystoreConfig := givenKeystoreConfig(signingDir)
+	expectedKeystoreConfig.Path = "file://" + filepath.Join(signingDir, "keystore")
+	require.Equal(t, expectedKeystoreConfig, actualKeystoreConfig)
+	assertFileContent(t, filepath.Join(signingDir, "keystore"), "keystore")
+}
+
+func Test_parseKeystoreConfig_pemKeyPair(t *testing.T) {
+	signingDir := t.TempDir()
+	keyPEM, certificatePEM := givenPEMKeyPair(t)
+	certificatePath := filepath.Join(t.TempDir(), "certificate.pem")
+	require.NoError(t, os.WriteFile(certificatePath, certificatePEM, 0600))
+	config := givenConfig()
+	config.KeystoreURL = ""
+	config.KeyAlias = ""
+	config.SigningKeyPEM = stepconf.Secret(keyPEM)
+	config.SigningCertificatePEM = certificatePath
+
+	actualKeystoreConfig, err := parseKeystoreConfig(config, givenStdin(""), signingDir)
+
+	require.NoError(t, err)
+	keystorePath := filepath.Join(signingDir, "keystore.p12")
+	require.Equal(t, "file://"+keystorePath, actualKeystoreConfig.Path)
+	require.Equal(t, "key0", actualKeystoreConfig.SigningKeyAlias)
+	require.Equal(t, actualKeystoreConfig.KeystorePassword, actualKeystoreConfig.SigningKeyPassword)
+	password, err := bundletool.PasswordFromArg(actualKeystoreConfig.KeystorePassword)
+	require.NoError(t, err)
+	ks, err := keystore.Open(keystorePath, password)
+	require.NoError(t, err)
+	require.NoError(t, ks.CheckKey("key0", password))
+}
+
+func Test_parseKeystoreConfig_multipleKeystoreSources(t *testing.T) {
+	config := givenConfig()
+	config.KeystoreBase64 = "a2V5c3RvcmU="
+
+	parsedKeystoreConfig, err := parseKeystoreConfig(config, givenStdin(""), t.TempDir())
 
+	require.EqualError(t, err, "only one of keystore_url, keystore_base64 and signing_key_pem can be set")
 	require.Nil(t, parsedKeystoreConfig)
 }
 
+func Test_parseKeystoreConfig_missingAlias(t *testing.T) {
+	config := givenConfig()
+	config.KeyAlias = ""
+
+	passwordDir := t.TempDir()
+	parsedKeystoreConfig, err := parseKeystoreConfig(config, givenStdin(""), passwordDir)
+
+	require.NoError(t, err)
+	expectedKeystoreConfig := givenKeystoreConfig(passwordDir)
+	expectedKeystoreConfig.SigningKeyAlias = ""
+	require.Equal(t, expectedKeystoreConfig, parsedKeystoreConfig)
+}
+
+func Test_parseKeystoreConfig_literalPasswordPrefix(t *testing.T) {
+	passwordDir := t.TempDir()
+	config := givenConfig()
+	config.KeystotePassword = "pass:secret"
+	config.KeystorePasswordSource = "literal"
+	config.KeyPassword = "file:secret"
+
+	actualKeystoreConfig, err := parseKeystoreConfig(config, givenStdin(""), passwordDir)
+
+	require.NoError(t, err)
+	require.Equal(t, givenKeystoreConfig(passwordDir), actualKeystoreConfig)
+	assertFileContent(t, filepath.Join(passwordDir, "keystore_password"), "pass:secret")
+	assertFileContent(t, filepath.Join(passwordDir, "private_key_password"), "file:secret")
+}
+
+func Test_parseKeystoreConfig_legacyPasswords(t *testing.T) {
+	passwordDir := t.TempDir()
+	keyPasswordPath := filepath.Join(t.TempDir(), "key_password")
+	require.NoError(t, os.WriteFile(keyPasswordPath, []byte(KeyPassword+"\n"), 0600))
+	config := givenConfig()
+	config.KeystotePassword = "pass:" + KeystotePassword
+	config.KeystorePasswordSource = "legacy"
+	config.KeyPassword = stepconf.Secret("file:" + keyPasswordPath)
+	config.KeyPasswordSource = "legacy"
+
+	actualKeystoreConfig, err := parseKeystoreConfig(config, givenStdin(""), passwordDir)
+
+	require.NoError(t, err)
+	expectedKeystoreConfig := givenKeystoreConfig(passwordDir)
+	expectedKeystoreConfig.SigningKeyPassword = "file:" + keyPasswordPath
+	require.Equal(t, expectedKeystoreConfig, actualKeystoreConfig)
+	assertFileContent(t, filepath.Join(passwordDir, "keystore_password"), KeystotePassword)
+}
+
+func Test_applyKeystoreProperties(t *testing.T) {
+	dir := t.TempDir()
+	propertiesPath := filepath.Join(dir, "keystore.properties")
+	require.NoError(t, os.WriteFile(propertiesPath, []byte("storeFile=release.jks\nstorePassword=store pass\nkeyAlias=upload\nkeyPassword=key pass\n"), 0600))
+	config := givenConfig()
+	config.KeystorePropertiesPath = propertiesPath
+	config.KeystoreURL = ""
+	config.KeystotePassword = ""
+	config.KeyPassword = "KEY_PASSWORD"
+	config.KeyPasswordSource = "env"
+
+	actualConfig, err := applyKeystoreProperties(config)
+
+	require.NoError(t, err)
+	expectedConfig := config
+	expectedConfig.KeystoreURL = stepconf.Secret("file://" + filepath.Join(dir, "release.jks"))
+	expectedConfig.KeystotePassword = "store pass"
+	expectedConfig.KeystorePasswordSource = "literal"
+	require.Equal(t, expectedConfig, actualConfig)
+}
+
+func Test_registerSecrets(t *testing.T) {
+	config := givenConfig()
+	config.KeystoreURL = "https://example.com/keystore.jks?signature=abcdef"
+	keystoreConfig := givenKeystoreConfig(t.TempDir())
+	keystoreConfig.KeystorePassword = "pass:store password read from stdin"
+
+	registerSecrets(config)
+	registerPasswordSecrets(*keystoreConfig)
+	redacted := secrets.Redact(fmt.Sprintf("bundletool build-apks --ks %s --ks-pass %s --ks-key-alias %s --key-pass %s failed: %s",
+		string(config.KeystoreURL), string(config.KeystotePassword), string(config.KeyAlias), string(config.KeyPassword), keystoreConfig.KeystorePassword))
+
+	require.Equal(t, "bundletool build-apks --ks https://example.com/keystore.jks?[REDACTED] --ks-pass [REDACTED] --ks-key-alias [REDACTED] --key-pass [REDACTED] failed: pass:[REDACTED]", redacted)
+}
+
+func Test_parseBuildAPKsOptions(t *testing.T) {
+	config := givenConfig()
+	config.Modules = " base, feature ,"
+	config.OptimizeFor = "abi"
+	config.LocalTesting = true
+
+	opts := parseBuildAPKsOptions(config, bundletool.ModeDefault)
+
+	require.Equal(t, bundletool.BuildAPKsOptions{
+		Mode:         bundletool.ModeDefault,
+		Modules:      []string{"base", "feature"},
+		OptimizeFor:  "abi",
+		LocalTesting: true,
+	}, opts)
+}
+
+func Test_parseAABPaths(t *testing.T) {
+	config := givenConfig()
+	config.AABPathList = "/path/to/app-free-release.aab| /path/to/app-paid-release.aab |"
+
+	aabPaths, err := parseAABPaths(config)
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"/path/to/app-free-release.aab", "/path/to/app-paid-release.aab"}, aabPaths)
+}
+
+func Test_parseAABPaths_singleBundle(t *testing.T) {
+	aabPaths, err := parseAABPaths(givenConfig())
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"/path/to/app.aab"}, aabPaths)
+}
+
+func Test_parseAABPaths_missing(t *testing.T) {
+	config := givenConfig()
+	config.AABPath = ""
+
+	aabPaths, err := parseAABPaths(config)
+
+	require.EqualError(t, err, "neither aab_path nor aab_path_list is set")
+	require.Nil(t, aabPaths)
+}
+
+func Test_parseAABPaths_discovery(t *testing.T) {
+	deployDir := t.TempDir()
+	for _, name := range []string{"app-demo-release.aab", "app-full-release.aab", "app-full-debug.aab"} {
+		require.NoError(t, os.WriteFile(filepath.Join(deployDir, name), nil, 0600))
+	}
+	config := givenConfig()
+	config.DeployDir = deployDir
+	config.AABDiscovery = true
+	config.IncludeBuildTypes = "release"
+	config.ExcludeFlavors = "demo"
+
+	aabPaths, err := parseAABPaths(config)
+
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(deployDir, "app-full-release.aab")}, aabPaths)
+}
+
+func Test_verifyDeviceAPKs_Rejected(t *testing.T) {
+	dir := t.TempDir()
+	var apkPaths []string
+	for _, name := range []string{"base-master.apk", "base-arm64_v8a.apk"} {
+		pth := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(pth, []byte("not an APK"), 0600))
+		apkPaths = append(apkPaths, pth)
+	}
+
+	err := verifyDeviceAPKs(apkPaths, nil, signingModeAuto)
+
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "base-master.apk: "), err.Error())
+	for _, pth := range apkPaths {
+		require.NoFileExists(t, pth)
+	}
+}
+
+func Test_verifyAPKSet_Rejected(t *testing.T) {
+	apksPath := filepath.Join(t.TempDir(), "app.apks")
+	f, err := os.Create(apksPath)
+	require.NoError(t, err)
+	w := zip.NewWriter(f)
+	for _, name := range []string{"toc.pb", "splits/base-master.apk"} {
+		entry, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = entry.Write([]byte("not an APK"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	err = verifyAPKSet(apksPath, t.TempDir(), nil, signingModeAuto)
+
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), filepath.Join("splits", "base-master.apk")+": "), err.Error())
+	require.NoFileExists(t, apksPath)
+}
+
+func Test_checkSigners(t *testing.T) {
+	release := givenSignatureResult(t, "Release")
+	debug := givenSignatureResult(t, "Android Debug")
+	releaseFingerprint := release.Signers[0].SHA256Fingerprint
+
+	require.NoError(t, checkSigners(release, []string{releaseFingerprint}, signingModeRequireRelease))
+	require.NoError(t, checkSigners(debug, nil, signingModeAuto))
+	require.EqualError(t, checkSigners(debug, nil, signingModeRequireRelease), "signing mode is require_release, but the APK is signed with the Android debug certificate")
+	require.Error(t, checkSigners(debug, []string{releaseFingerprint}, signingModeAuto))
+}
+
 func givenConfig() Config {
 	return Config{
 		DeployDir:        "/path/to/dir",
@@ -39,13 +342,66 @@ func givenConfig() Config {
 		KeystotePassword: KeystotePassword,
 		KeyAlias:         KeyAlias,
 		KeyPassword:      KeyPassword,
+		SigningMode:      signingModeAuto,
 	}
 }
 
-func givenKeystoreConfig() *bundletool.KeystoreConfig {
+func givenKeystoreConfig(passwordDir string) *bundletool.KeystoreConfig {
 	return &bundletool.KeystoreConfig{
 		Path:               KeystoreURL,
-		KeystorePassword:   KeystotePassword,
+		KeystorePassword:   "file:" + filepath.Join(passwordDir, "keystore_password"),
 		SigningKeyAlias:    KeyAlias,
-		SigningKeyPassword: KeyPassword}
+		SigningKeyPassword: "file:" + filepath.Join(passwordDir, "private_key_password")}
+}
+
+func givenPEMKeyPair(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Release"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateDER})
+}
+
+func givenSignatureResult(t *testing.T, commonName string) apksignature.Result {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return apksignature.Result{
+		Schemes: []apksignature.Scheme{apksignature.SchemeV2},
+		Signers: []apksignature.Signer{{
+			Subject:           certificate.Subject.String(),
+			SHA256Fingerprint: apksignature.Fingerprint(certificate),
+			Certificate:       certificate,
+		}},
+	}
+}
+
+func givenStdin(content string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(content))
+}
+
+func assertFileContent(t *testing.T, pth, expected string) {
+	content, err := os.ReadFile(pth)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(content))
 }
@@ -0,0 +1,119 @@
+// Package redactor masks the secrets known by the Step in log lines.
+package redactor
+
+import (
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces the redacted secrets.
+const Mask = "[REDACTED]"
+
+// Redactor masks registered secrets, it is safe for concurrent use.
+type Redactor struct {
+	mu           sync.RWMutex
+	replacements map[string]string
+	replacer     *strings.Replacer
+}
+
+// New creates a Redactor masking the given secrets.
+func New(secrets ...string) *Redactor {
+	redactor := &Redactor{replacements: map[string]string{}}
+	redactor.Add(secrets...)
+	return redactor
+}
+
+// Add registers secrets to mask, empty values are ignored.
+// The URL query escaped form of the secrets is masked as well.
+func (redactor *Redactor) Add(secrets ...string) {
+	redactor.mu.Lock()
+	defer redactor.mu.Unlock()
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		redactor.replacements[secret] = Mask
+		redactor.replacements[url.QueryEscape(secret)] = Mask
+	}
+	redactor.updateReplacer()
+}
+
+// AddURL registers a URL, which is shown without its query string (it may contain a signature).
+// The query string and the URL's user info are masked wherever they appear.
+func (redactor *Redactor) AddURL(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.RawQuery == "" && u.User == nil) {
+		return
+	}
+
+	secrets := []string{u.RawQuery}
+	if u.User != nil {
+		secrets = append(secrets, u.User.String())
+	}
+	redactor.Add(secrets...)
+
+	safeURL := *u
+	safeURL.User = nil
+	safeURL.RawQuery = ""
+	if u.RawQuery != "" {
+		safeURL.RawQuery = Mask
+	}
+
+	redactor.mu.Lock()
+	defer redactor.mu.Unlock()
+	redactor.replacements[rawURL] = safeURL.String()
+	redactor.updateReplacer()
+}
+
+// Redact returns s with all the registered secrets masked.
+func (redactor *Redactor) Redact(s string) string {
+	redactor.mu.RLock()
+	defer redactor.mu.RUnlock()
+
+	if redactor.replacer == nil {
+		return s
+	}
+	return redactor.replacer.Replace(s)
+}
+
+// Writer returns a writer masking the secrets in every Write call before writing to w.
+// Log lines are written with a single Write call, so secrets are not split between calls.
+func (redactor *Redactor) Writer(w io.Writer) io.Writer {
+	return writer{redactor: redactor, w: w}
+}
+
+// updateReplacer rebuilds the replacer, longer secrets first so that they are masked as a whole.
+func (redactor *Redactor) updateReplacer() {
+	var secrets []string
+	for secret := range redactor.replacements {
+		secrets = append(secrets, secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		if len(secrets[i]) != len(secrets[j]) {
+			return len(secrets[i]) > len(secrets[j])
+		}
+		return secrets[i] < secrets[j]
+	})
+
+	var oldnew []string
+	for _, secret := range secrets {
+		oldnew = append(oldnew, secret, redactor.replacements[secret])
+	}
+	redactor.replacer = strings.NewReplacer(oldnew...)
+}
+
+type writer struct {
+	redactor *Redactor
+	w        io.Writer
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.redactor.Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,62 @@
+package redactor
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	keystoreURL   = "https://storage.example.com/keystores/release.jks?X-Amz-Signature=abc123&X-Amz-Expires=600"
+	storePassword = "store p@ss"
+	keyPassword   = "pass:key"
+	alias         = "release-key"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	// Given
+	redactor := New(storePassword, keyPassword, alias)
+	redactor.AddURL(keystoreURL)
+
+	// When
+	redacted := redactor.Redact("Download keystore from: " + keystoreURL + ", alias: " + alias + ", query: X-Amz-Signature=abc123&X-Amz-Expires=600, escaped: store+p%40ss")
+
+	// Then
+	require.Equal(t, "Download keystore from: https://storage.example.com/keystores/release.jks?[REDACTED], alias: [REDACTED], query: [REDACTED], escaped: [REDACTED]", redacted)
+}
+
+func TestRedactor_Redact_OverlappingSecrets(t *testing.T) {
+	// Given
+	redactor := New("pass", "password")
+
+	// When
+	redacted := redactor.Redact("--ks-pass pass:password")
+
+	// Then
+	require.Equal(t, "--ks-[REDACTED] [REDACTED]:[REDACTED]", redacted)
+}
+
+func TestRedactor_Writer_Log(t *testing.T) {
+	// Given
+	redactor := New(storePassword, keyPassword, alias)
+	redactor.AddURL(keystoreURL)
+	var out bytes.Buffer
+	log.SetOutWriter(redactor.Writer(&out))
+	defer log.SetOutWriter(os.Stdout)
+	cmd := command.New("bundletool", "build-apks", "--ks", keystoreURL, "--ks-pass", "pass:"+storePassword, "--ks-key-alias", alias, "--key-pass", "pass:"+keyPassword)
+
+	// When
+	log.Infof("Download keystore from: %s", keystoreURL)
+	log.Errorf("Failed to export apk, error: %s failed: %s", cmd.PrintableCommandArgs(), errors.New("Keystore password: "+storePassword))
+
+	// Then
+	for _, secret := range []string{storePassword, keyPassword, alias, "abc123", "X-Amz-Signature"} {
+		require.NotContains(t, out.String(), secret)
+	}
+	require.Contains(t, out.String(), "https://storage.example.com/keystores/release.jks?[REDACTED]")
+}
@@ -0,0 +1,186 @@
+// Package workspace manages the Step's private temporary directories, and removes them with the sensitive files in them.
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	dirPermission  = 0700
+	filePermission = 0600
+)
+
+// Workspace is a temporary directory tree only accessible by the current user.
+type Workspace struct {
+	root string
+
+	mu            sync.Mutex
+	sensitiveDirs []string
+	cleaned       bool
+}
+
+// New creates a workspace in the OS temp dir, named after the prefix.
+func New(prefix string) (*Workspace, error) {
+	root, err := os.MkdirTemp("", prefix)
+	if err != nil {
+		return nil, err
+	}
+	// MkdirTemp creates 0700 directories, but be explicit in case of an unusual umask
+	if err := os.Chmod(root, dirPermission); err != nil {
+		return nil, err
+	}
+	return &Workspace{root: root}, nil
+}
+
+// Root returns the workspace's root directory.
+func (ws *Workspace) Root() string {
+	return ws.root
+}
+
+// Dir creates a new, uniquely named directory in the workspace.
+func (ws *Workspace) Dir(pattern string) (string, error) {
+	return os.MkdirTemp(ws.root, pattern)
+}
+
+// SensitiveDir creates a new directory, the files of which are overwritten before removal.
+// It is meant for keystores and password files.
+func (ws *Workspace) SensitiveDir(pattern string) (string, error) {
+	dir, err := ws.Dir(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.sensitiveDirs = append(ws.sensitiveDirs, dir)
+	return dir, nil
+}
+
+// WriteSensitiveFile writes data to a file only readable by the current user.
+func WriteSensitiveFile(pth string, data []byte) error {
+	f, err := os.OpenFile(pth, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePermission)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// RestrictPermissions makes a file only readable by the current user, for example a downloaded keystore.
+func RestrictPermissions(pth string) error {
+	return os.Chmod(pth, filePermission)
+}
+
+// Cleanup overwrites the files of the sensitive directories, then removes the workspace.
+// It is safe to call it multiple times, from multiple goroutines.
+func (ws *Workspace) Cleanup() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.cleaned {
+		return nil
+	}
+	ws.cleaned = true
+
+	var errs []string
+	for _, dir := range ws.sensitiveDirs {
+		if err := shredDir(dir); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := os.RemoveAll(ws.root); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to clean up workspace (%s): %s", ws.root, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// CleanupOnSignal cleans up the workspace and exits if the process receives SIGINT or SIGTERM.
+// The returned function stops the signal handling.
+func (ws *Workspace) CleanupOnSignal() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warnf("Received %s, cleaning up", sig)
+			if err := ws.Cleanup(); err != nil {
+				log.Warnf("%s", err)
+			}
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+		})
+	}
+}
+
+// shredDir overwrites the regular files in dir.
+func shredDir(dir string) error {
+	return filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return overwrite(pth, info.Size())
+	})
+}
+
+// overwrite replaces the content of a file with zeros and flushes it to the disk.
+// On copy-on-write or journaling file systems and SSDs the original blocks may still survive,
+// the file is overwritten to not leave it readable in the OS temp dir after an interrupted removal.
+func overwrite(pth string, size int64) error {
+	f, err := os.OpenFile(pth, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, zeroReader{}, size); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,76 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkspace(t *testing.T) {
+	// Given
+	ws, err := New("workspace-test")
+	require.NoError(t, err)
+	sensitiveDir, err := ws.SensitiveDir("keystore")
+	require.NoError(t, err)
+	keystorePath := filepath.Join(sensitiveDir, "release.jks")
+	require.NoError(t, WriteSensitiveFile(keystorePath, []byte("keystore")))
+	dir, err := ws.Dir("apks")
+	require.NoError(t, err)
+
+	// When
+	cleanupErr := ws.Cleanup()
+
+	// Then
+	require.NoError(t, cleanupErr)
+	for _, pth := range []string{ws.Root(), sensitiveDir, dir} {
+		_, err := os.Stat(pth)
+		require.True(t, os.IsNotExist(err), pth)
+	}
+	require.NoError(t, ws.Cleanup())
+}
+
+func TestWorkspace_Permissions(t *testing.T) {
+	// Given
+	ws, err := New("workspace-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, ws.Cleanup())
+	}()
+	dir, err := ws.SensitiveDir("keystore")
+	require.NoError(t, err)
+	pth := filepath.Join(dir, "password")
+
+	// When
+	err = WriteSensitiveFile(pth, []byte("secret"))
+
+	// Then
+	require.NoError(t, err)
+	assertPermission(t, ws.Root(), 0700)
+	assertPermission(t, dir, 0700)
+	assertPermission(t, pth, 0600)
+}
+
+func Test_shredDir(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	pth := filepath.Join(dir, "nested", "release.jks")
+	require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0700))
+	require.NoError(t, os.WriteFile(pth, []byte("keystore"), 0600))
+
+	// When
+	err := shredDir(dir)
+
+	// Then
+	require.NoError(t, err)
+	content, err := os.ReadFile(pth)
+	require.NoError(t, err)
+	require.Equal(t, make([]byte, len("keystore")), content)
+}
+
+func assertPermission(t *testing.T, pth string, perm os.FileMode) {
+	info, err := os.Stat(pth)
+	require.NoError(t, err)
+	require.Equal(t, perm, info.Mode().Perm(), pth)
+}